@@ -0,0 +1,284 @@
+package zglob
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"unicode"
+)
+
+// Overlaps reports whether some path could match both a and b, without
+// looking at the file system. It walks the product of the automata of the
+// two patterns, restricted to the cleaned paths Match accepts, so it is
+// exact for the paths their regular expressions accept. Both patterns
+// should be compiled with the same options; with NormalizationInsensitive
+// they are compared in NFC. Patterns restricted to different types of
+// entries, by a trailing slash or OnlyType, never overlap.
+func Overlaps(a, b *Pattern) bool {
+	if a.absolute() != b.absolute() || !a.typesOverlap(b) {
+		return false
+	}
+	progs := []*syntax.Prog{a.automaton(), a.names(), b.automaton(), b.names()}
+	found := false
+	explore(progs, len(progs), func(acc []bool) bool {
+		found = acc[0] && acc[1] && acc[2] && acc[3]
+		return !found
+	})
+	return found
+}
+
+// Subsumes reports whether a matches every path that b matches, so that b
+// is redundant next to a, without looking at the file system. Like
+// Overlaps, it works on the automata of the two patterns. A pattern
+// restricted to some types of entries, by a trailing slash or OnlyType,
+// does not subsume one that returns other types.
+func Subsumes(a, b *Pattern) bool {
+	if a.absolute() != b.absolute() || !a.typesSubsume(b) {
+		return false
+	}
+	progs := []*syntax.Prog{b.automaton(), b.names(), a.automaton(), a.names()}
+	subsumed := true
+	explore(progs, 2, func(acc []bool) bool {
+		subsumed = !(acc[0] && acc[1]) || acc[2] && acc[3]
+		return subsumed
+	})
+	return subsumed
+}
+
+func (z *Pattern) absolute() bool {
+	return filepath.IsAbs(z.pattern)
+}
+
+// typeProbes are the kinds of entries that matchType tells apart.
+var typeProbes = []os.FileMode{os.ModeDir, 0}
+
+// typesOverlap reports whether z and other return some type in common.
+func (z *Pattern) typesOverlap(other *Pattern) bool {
+	for _, typ := range typeProbes {
+		if z.matchType(typ) && other.matchType(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// typesSubsume reports whether z returns every type that other returns.
+func (z *Pattern) typesSubsume(other *Pattern) bool {
+	for _, typ := range typeProbes {
+		if other.matchType(typ) && !z.matchType(typ) {
+			return false
+		}
+	}
+	return true
+}
+
+// automaton returns the program of the regular expression of z, or of
+// the literal path for a pattern without wildcards.
+func (z *Pattern) automaton() *syntax.Prog {
+	expr := "^" + regexp.QuoteMeta(path.Clean(z.pattern)) + "$"
+	if z.fre != nil {
+		expr = z.fre.String()
+	}
+	// z.fre was compiled from the same expression.
+	return mustCompile(expr)
+}
+
+// cleanSegment matches a path segment other than . and ..
+const cleanSegment = `(?:[^./][^/]*|\.[^./][^/]*|\.\.[^/]+)`
+
+// names returns the program accepting the cleaned paths Match can accept
+// for z: any path for a pattern without wildcards, whose automaton is
+// exact, absolute ones for an absolute pattern, ones that may start with
+// .. for a pattern whose roots leave the current directory, and ones that
+// don't otherwise.
+func (z *Pattern) names() *syntax.Prog {
+	segs := cleanSegment + `(?:/` + cleanSegment + `)*`
+	switch {
+	case z.root == "":
+		return mustCompile(`(?s:.*)`)
+	case z.absolute():
+		return mustCompile(`^(?:[A-Za-z]:)?/(?:` + segs + `)?$`)
+	case !z.parent:
+		return mustCompile(`^` + segs + `$`)
+	}
+	return mustCompile(`^(?:\.\./)*(?:\.\.|` + segs + `)$`)
+}
+
+func mustCompile(expr string) *syntax.Prog {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		panic(err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		panic(err)
+	}
+	return prog
+}
+
+// explore visits the states of the product of progs reachable by some
+// string, calling visit with whether each program accepts, until visit
+// returns false. Only strings that keep the first alive programs alive
+// are followed.
+func explore(progs []*syntax.Prog, alive int, visit func(acc []bool) bool) {
+	begin := syntax.EmptyBeginText | syntax.EmptyBeginLine
+	end := syntax.EmptyEndText | syntax.EmptyEndLine
+	type state struct {
+		sets  [][]uint32
+		start bool
+	}
+
+	first := state{start: true}
+	for _, prog := range progs {
+		first.sets = append(first.sets, closure(prog, []uint32{uint32(prog.Start)}, begin))
+	}
+	acc := make([]bool, len(progs))
+	seen := map[string]bool{}
+	queue := []state{first}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		at := end
+		if s.start {
+			at |= begin
+		}
+		for i, prog := range progs {
+			acc[i] = accepts(prog, s.sets[i], at)
+		}
+		if !visit(acc) {
+			return
+		}
+	next:
+		for _, r := range representatives(progs, s.sets) {
+			n := state{sets: make([][]uint32, len(progs))}
+			for i, prog := range progs {
+				n.sets[i] = step(prog, s.sets[i], r)
+				if i < alive && len(n.sets[i]) == 0 {
+					continue next
+				}
+			}
+			key := fmt.Sprint(n.sets)
+			if !seen[key] {
+				seen[key] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+}
+
+// closure returns the sorted instructions reachable from pcs without
+// consuming input, given the empty-width conditions that hold. It keeps
+// instructions that consume a rune, match, or wait for other conditions.
+func closure(prog *syntax.Prog, pcs []uint32, empty syntax.EmptyOp) []uint32 {
+	seen := map[uint32]bool{}
+	var out []uint32
+	var add func(pc uint32)
+	add = func(pc uint32) {
+		if seen[pc] {
+			return
+		}
+		seen[pc] = true
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			add(inst.Out)
+			add(inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			add(inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^empty == 0 {
+				add(inst.Out)
+			} else {
+				out = append(out, pc)
+			}
+		case syntax.InstFail:
+		default:
+			out = append(out, pc)
+		}
+	}
+	for _, pc := range pcs {
+		add(pc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// accepts reports whether the set of instructions matches when the
+// empty-width conditions in empty hold, as they do at the end of input.
+func accepts(prog *syntax.Prog, set []uint32, empty syntax.EmptyOp) bool {
+	for _, pc := range closure(prog, set, empty) {
+		if prog.Inst[pc].Op == syntax.InstMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// step returns the set of instructions reached from set by consuming r.
+func step(prog *syntax.Prog, set []uint32, r rune) []uint32 {
+	var next []uint32
+	for _, pc := range set {
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+			if inst.MatchRune(r) {
+				next = append(next, inst.Out)
+			}
+		}
+	}
+	if len(next) == 0 {
+		return nil
+	}
+	return closure(prog, next, 0)
+}
+
+// representatives splits the runes into intervals that every instruction
+// of the sets treats alike, and returns the first rune of each.
+func representatives(progs []*syntax.Prog, sets [][]uint32) []rune {
+	bounds := map[rune]bool{0: true}
+	cut := func(lo, hi rune, fold bool) {
+		bounds[lo] = true
+		if hi < unicode.MaxRune {
+			bounds[hi+1] = true
+		}
+		if fold && lo == hi {
+			for f := unicode.SimpleFold(lo); f != lo; f = unicode.SimpleFold(f) {
+				bounds[f] = true
+				bounds[f+1] = true
+			}
+		}
+	}
+	collect := func(prog *syntax.Prog, set []uint32) {
+		for _, pc := range set {
+			inst := &prog.Inst[pc]
+			switch inst.Op {
+			case syntax.InstRune:
+				fold := syntax.Flags(inst.Arg)&syntax.FoldCase != 0
+				if len(inst.Rune) == 1 {
+					cut(inst.Rune[0], inst.Rune[0], fold)
+				}
+				for i := 0; i+1 < len(inst.Rune); i += 2 {
+					cut(inst.Rune[i], inst.Rune[i+1], fold)
+				}
+			case syntax.InstRune1:
+				cut(inst.Rune[0], inst.Rune[0], false)
+			case syntax.InstRuneAnyNotNL:
+				cut('\n', '\n', false)
+			}
+		}
+	}
+	for i, prog := range progs {
+		collect(prog, sets[i])
+	}
+
+	reps := make([]rune, 0, len(bounds))
+	for r := range bounds {
+		reps = append(reps, r)
+	}
+	sort.Slice(reps, func(i, j int) bool { return reps[i] < reps[j] })
+	return reps
+}
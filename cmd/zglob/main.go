@@ -9,11 +9,30 @@ import (
 )
 
 func main() {
-	var d bool
+	if len(os.Args) > 1 && os.Args[1] == "rename" {
+		os.Exit(runRename(os.Args[2:]))
+	}
+
+	var d, explain, trace bool
 	flag.BoolVar(&d, "d", false, "with directory")
+	flag.BoolVar(&explain, "explain", false, "explain how each pattern is compiled instead of matching it")
+	flag.BoolVar(&trace, "trace", false, "log each directory read, descended into or skipped to stderr")
 	flag.Parse()
-	for _, arg := range os.Args[1:] {
-		matches, err := zglob.Glob(arg)
+	var opts []zglob.Option
+	if trace {
+		opts = append(opts, zglob.Trace(os.Stderr))
+	}
+	for _, arg := range flag.Args() {
+		if explain {
+			z, err := zglob.New(arg, opts...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			fmt.Print(z.Explain())
+			continue
+		}
+		matches, err := zglob.Glob(arg, opts...)
 		if err != nil {
 			continue
 		}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-zglob/rename"
+)
+
+func runRename(args []string) int {
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	var n, f, v bool
+	fs.BoolVar(&n, "n", false, "dry run: print renames without performing them")
+	fs.BoolVar(&f, "f", false, "overwrite existing destinations")
+	fs.BoolVar(&v, "v", false, "print each rename as it is performed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: zglob rename [-n] [-f] [-v] pattern template")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	renames, err := rename.Plan(fs.Arg(0), fs.Arg(1), f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if n || v {
+		for _, r := range renames {
+			fmt.Printf("%s -> %s\n", r.From, r.To)
+		}
+	}
+	if n {
+		return 0
+	}
+	if err := rename.Apply(renames); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package zglob
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Trace makes Glob write a line to w for every directory it reads, descends
+// into or skips, with the reason, to help find out why a pattern does not
+// match as expected.
+func Trace(w io.Writer) Option {
+	return func(o *options) {
+		o.trace = w
+	}
+}
+
+var traceMu sync.Mutex
+
+func (z *Pattern) trace(format string, args ...interface{}) {
+	if z.opts.trace == nil {
+		return
+	}
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	fmt.Fprintf(z.opts.trace, format+"\n", args...)
+}
+
+// skipDir reports whether the walker should skip the directory path at
+// depth, tracing the decision.
+func (z *Pattern) skipDir(path string, depth int) bool {
+	switch {
+	case !z.descend(depth):
+		z.trace("skip %s: at MaxDepth %d", path, z.opts.maxDepth)
+	case !z.CouldMatchUnder(path):
+		z.trace("skip %s: nothing below can match", path)
+	default:
+		z.trace("descend %s", path)
+		return false
+	}
+	return true
+}
+
+// Explain returns a human-readable breakdown of the compiled pattern: its
+// tokens, the root Glob starts from, how Glob will find matches, the
+// segments used to prune directories and the regular expression names are
+// matched against.
+func (z *Pattern) Explain() string {
+	var b strings.Builder
+	line := func(key, format string, args ...interface{}) {
+		fmt.Fprintf(&b, "%-8s %s\n", key, fmt.Sprintf(format, args...))
+	}
+	line("pattern", "%s", z.pattern)
+	if z.root == "" {
+		line("tokens", "%q", z.pattern)
+		line("plan", "stat %s", z.pattern)
+		return b.String()
+	}
+
+	var toks []string
+	for _, t := range tokens(path.Clean(z.pattern)) {
+		toks = append(toks, fmt.Sprintf("%q", t))
+	}
+	line("tokens", "%s", strings.Join(toks, " "))
+	line("root", "%s", z.root)
+	if paths, ok := z.literals(); ok {
+		line("plan", "lstat %s", strings.Join(paths, ", "))
+	} else if z.readsLevels() {
+		line("plan", "read directories level by level")
+	} else {
+		roots, _ := z.roots()
+		line("plan", "walk %s", strings.Join(roots, ", "))
+	}
+	if z.segs == nil {
+		line("prune", "none")
+	} else {
+		var segs []string
+		for _, seg := range z.segs {
+			switch seg {
+			case nil:
+				segs = append(segs, "(any depth)")
+			case anySegment:
+				segs = append(segs, "(any name)")
+			default:
+				segs = append(segs, seg.String())
+			}
+		}
+		line("prune", "%s", strings.Join(segs, " / "))
+	}
+	line("regexp", "%s", z.fre)
+	if z.alt != nil {
+		line("", "%s", z.alt)
+	}
+	return b.String()
+}
+
+// tokens splits a slash-separated pattern into runs of literal text and
+// wildcards, the latter in the order of their Submatch groups.
+func tokens(pattern string) []string {
+	var toks []string
+	var lit strings.Builder
+	add := func(tok string) {
+		if lit.Len() > 0 {
+			toks = append(toks, lit.String())
+			lit.Reset()
+		}
+		toks = append(toks, tok)
+	}
+	// until returns the end of the group starting at i, which is closed
+	// by c, or len(cc) if it isn't closed.
+	cc := []rune(pattern)
+	until := func(i int, c rune) int {
+		for j := i + 1; j < len(cc); j++ {
+			if cc[j] == '\\' && j < len(cc)-1 {
+				j++
+			} else if cc[j] == c {
+				return j + 1
+			}
+		}
+		return len(cc)
+	}
+	for i := 0; i < len(cc); i++ {
+		switch c := cc[i]; {
+		case c == '\\' && i < len(cc)-1:
+			lit.WriteRune(cc[i])
+			i++
+			lit.WriteRune(cc[i])
+		case c == '*':
+			switch {
+			case i < len(cc)-2 && cc[i+1] == '*' && cc[i+2] == '/':
+				add("**/")
+				i += 2
+			case i < len(cc)-1 && cc[i+1] == '*':
+				add("**")
+				i++
+			default:
+				add("*")
+			}
+		case c == '?':
+			add("?")
+		case c == '[' || c == '{':
+			closer := ']'
+			if c == '{' {
+				closer = '}'
+			}
+			end := until(i, closer)
+			add(string(cc[i:end]))
+			i = end - 1
+		case c == '!' && i < len(cc)-1 && cc[i+1] == '(':
+			end := until(i+1, ')')
+			add(string(cc[i:end]))
+			i = end - 1
+		default:
+			lit.WriteRune(c)
+		}
+	}
+	if lit.Len() > 0 {
+		toks = append(toks, lit.String())
+	}
+	return toks
+}
@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // TraverseLink is a sentinel error for fastWalk, similar to filepath.SkipDir.
@@ -40,34 +41,159 @@ var TraverseLink = errors.New("traverse symlink, assuming target is a directory"
 //     sentinel error. It is the walkFn's responsibility to prevent
 //     fastWalk from going into symlink cycles.
 func FastWalk(root string, walkFn func(path string, typ os.FileMode) error) error {
+	return FastWalkWithOptions(root, walkFn, Options{})
+}
+
+// Entry describes a file or directory found by Walk.
+type Entry struct {
+	Path  string      // Dir and Name joined with the path separator
+	Dir   string      // parent directory
+	Name  string      // base name
+	Type  os.FileMode // type bits, as for FastWalk
+	Depth int         // 0 for the root, 1 for its entries, and so on
+}
+
+// Options configures FastWalkWithOptions.
+type Options struct {
+	// OnError is called with the path and error, an *os.PathError, when
+	// the root cannot be stat'ed or a directory cannot be read. If it
+	// returns nil the directory is skipped and the walk continues;
+	// otherwise the walk stops and returns that error. If OnError is nil,
+	// the first such error stops the walk.
+	OnError func(path string, err error) error
+
+	// Workers is the number of goroutines reading directories
+	// concurrently. Zero means the larger of 4 and runtime.NumCPU().
+	Workers int
+
+	// MaxQueued bounds the number of directories waiting to be read.
+	// Once about that many are queued, workers read newly found
+	// subdirectories themselves, depth first, instead of queueing them.
+	// Zero means no limit.
+	MaxQueued int
+
+	// BufferSize is the size of the buffer directory entries are read
+	// into. On Unix, a larger buffer needs fewer getdents system calls
+	// on huge directories. Buffers are reused across directories. Zero
+	// means 32 KiB.
+	BufferSize int
+
+	// Filter, if not nil, is called with the name of each directory
+	// entry before a string is allocated for it. Entries it rejects are
+	// skipped: walkFn is not called for them and directories are not
+	// read. The name must not be retained after Filter returns.
+	Filter func(name []byte, typ os.FileMode) bool
+
+	// Order selects the order in which callbacks are made. The default,
+	// Unordered, is the fastest.
+	Order Order
+
+	// SameDevice keeps the walk on the device of root, like find -xdev:
+	// walkFn is still called for a directory on another device, such as
+	// a mount point, but its entries are not read. It is ignored by the
+	// portable backend.
+	SameDevice bool
+}
+
+// Order is the order in which Walk calls its callback.
+type Order int
+
+const (
+	// Unordered calls the callback in an unspecified order: roughly
+	// depth first, with workers interleaved.
+	Unordered Order = iota
+
+	// BreadthFirst calls the callback for every entry at one depth
+	// before any entry at the next depth, so shallow entries are seen
+	// first. Directories of the same depth are still read in parallel.
+	// MaxQueued is ignored.
+	BreadthFirst
+
+	// Sorted calls the callback from a single goroutine in the same
+	// order as a sequential, lexically ordered depth-first walk such as
+	// filepath.Walk. Directories are read in parallel, but only once the
+	// callback has entered them or the sibling before them, so a
+	// directory it skips is rarely read. MaxQueued is ignored.
+	Sorted
+)
+
+const defaultBufferSize = 32 << 10
+
+// defaultBufs holds buffers of defaultBufferSize shared by all walks.
+var defaultBufs = &sync.Pool{New: func() interface{} {
+	buf := make([]byte, defaultBufferSize)
+	return &buf
+}}
+
+// FastWalkWithOptions is like FastWalk but configured by opts.
+func FastWalkWithOptions(root string, walkFn func(path string, typ os.FileMode) error, opts Options) error {
+	return Walk(root, func(e Entry) error {
+		return walkFn(e.Path, e.Type)
+	}, opts)
+}
+
+// Walk is like FastWalkWithOptions but passes fn an Entry carrying the
+// depth, parent directory and base name of each path, so callers don't
+// need to split paths again. Returning TraverseLink or filepath.SkipDir
+// from fn has the same effect as for FastWalk.
+func Walk(root string, fn func(e Entry) error, opts Options) error {
 	// Check if "root" is actually a file, not a directory.
 	stat, err := os.Stat(root)
 	if err != nil {
+		if opts.OnError != nil {
+			return opts.OnError(root, err)
+		}
 		return err
 	}
 	if !stat.IsDir() {
-		// If it is, just directly pass it to walkFn and return.
-		return walkFn(root, stat.Mode())
+		// If it is, just directly pass it to fn and return.
+		return fn(Entry{Path: root, Dir: filepath.Dir(root), Name: filepath.Base(root), Type: stat.Mode()})
 	}
 
-	// TODO(bradfitz): make numWorkers configurable? We used a
-	// minimum of 4 to give the kernel more info about multiple
-	// things we want, in hopes its I/O scheduling can take
-	// advantage of that. Hopefully most are in cache. Maybe 4 is
-	// even too low of a minimum. Profile more.
-	numWorkers := 4
-	if n := runtime.NumCPU(); n > numWorkers {
-		numWorkers = n
+	// We use a minimum of 4 workers by default to give the kernel
+	// more info about multiple things we want, in hopes its I/O
+	// scheduling can take advantage of that. Hopefully most are in
+	// cache.
+	numWorkers := opts.Workers
+	if numWorkers <= 0 {
+		numWorkers = 4
+		if n := runtime.NumCPU(); n > numWorkers {
+			numWorkers = n
+		}
+	}
+	bufSize := opts.BufferSize
+	if bufSize <= 0 {
+		bufSize = defaultBufferSize
 	}
 	w := &walker{
-		fn:       walkFn,
-		enqueuec: make(chan walkItem, numWorkers), // buffered for performance
-		workc:    make(chan walkItem, numWorkers), // buffered for performance
-		donec:    make(chan struct{}),
+		fn:        fn,
+		filter:    opts.Filter,
+		onError:   opts.OnError,
+		maxQueued: int64(opts.MaxQueued),
+		xdev:      opts.SameDevice,
+		order:     opts.Order,
+		queued:    1,                               // root
+		enqueuec:  make(chan walkItem, numWorkers), // buffered for performance
+		workc:     make(chan walkItem, numWorkers), // buffered for performance
+		donec:     make(chan struct{}),
 
 		// buffered for correctness & not leaking goroutines:
 		resc: make(chan error, numWorkers),
 	}
+	if w.xdev {
+		w.dev, w.xdev = fileDevice(stat)
+	}
+	w.bufs = defaultBufs
+	if bufSize != defaultBufferSize {
+		w.bufs = &sync.Pool{New: func() interface{} {
+			buf := make([]byte, bufSize)
+			return &buf
+		}}
+	}
+
+	if w.order == Sorted {
+		return w.walkSorted(root, numWorkers)
+	}
 
 	// TODO(bradfitz): start the workers as needed? maybe not worth it.
 	var wg sync.WaitGroup
@@ -76,19 +202,33 @@ func FastWalk(root string, walkFn func(path string, typ os.FileMode) error) erro
 		go w.doWork(&wg)
 	}
 
-	todo := []walkItem{{dir: root}}
+	todo := []walkItem{{dir: root, parentDir: filepath.Dir(root), name: filepath.Base(root)}}
 	out := 0
+	depth := 0 // depth of the items out, in breadth-first order
 	for {
 		workc := w.workc
 		var workItem walkItem
 		if len(todo) == 0 {
 			workc = nil
+		} else if w.order == BreadthFirst {
+			// Take the oldest item, and only start on the next depth
+			// once the current one is done.
+			workItem = todo[0]
+			if out > 0 && workItem.depth != depth {
+				workc = nil
+			}
 		} else {
 			workItem = todo[len(todo)-1]
 		}
 		select {
 		case workc <- workItem:
-			todo = todo[:len(todo)-1]
+			if w.order == BreadthFirst {
+				todo = todo[1:]
+				depth = workItem.depth
+			} else {
+				todo = todo[:len(todo)-1]
+			}
+			atomic.AddInt64(&w.queued, -1)
 			out++
 		case it := <-w.enqueuec:
 			todo = append(todo, it)
@@ -99,18 +239,20 @@ func FastWalk(root string, walkFn func(path string, typ os.FileMode) error) erro
 
 				// Drain the results channel from the other workers which
 				// haven't returned yet.
+				drained := make(chan struct{})
 				go func() {
 					for {
 						select {
-						case _, ok := <-w.resc:
-							if !ok {
-								return
-							}
+						case <-w.resc:
+						case <-drained:
+							return
 						}
 					}
 				}()
 
 				wg.Wait()
+				close(drained)
+				w.release(todo)
 				return err
 			}
 
@@ -146,13 +288,24 @@ func (w *walker) doWork(wg *sync.WaitGroup) {
 			wg.Done()
 			return
 		case it := <-w.workc:
-			w.resc <- w.walk(it.dir, !it.callbackDone)
+			w.resc <- w.walk(it)
 		}
 	}
 }
 
 type walker struct {
-	fn func(path string, typ os.FileMode) error
+	queued    int64 // directories enqueued but not yet handed to a worker
+	maxQueued int64
+
+	fn      func(e Entry) error
+	filter  func(name []byte, typ os.FileMode) bool
+	onError func(path string, err error) error
+	bufs    *sync.Pool // of *[]byte, for reading directory entries
+
+	xdev bool   // only read directories on dev
+	dev  uint64 // device of the root
+
+	order Order
 
 	donec    chan struct{} // closed on fastWalk's return
 	workc    chan walkItem // to workers
@@ -162,30 +315,71 @@ type walker struct {
 
 type walkItem struct {
 	dir          string
+	parent       *dirHandle // open parent directory, if any
+	parentDir    string
+	name         string
+	depth        int
+	link         bool // a symlink being traversed
 	callbackDone bool // callback already called; don't do it again
 }
 
-func (w *walker) enqueue(it walkItem) {
+// enqueue queues it for a worker, or walks it on the calling worker if
+// the queue is full.
+func (w *walker) enqueue(it walkItem) error {
+	if w.maxQueued > 0 && w.order == Unordered && atomic.LoadInt64(&w.queued) >= w.maxQueued {
+		return w.walk(it)
+	}
+	atomic.AddInt64(&w.queued, 1)
 	select {
 	case w.enqueuec <- it:
 	case <-w.donec:
+		it.parent.release()
 	}
+	return nil
 }
 
-func (w *walker) onDirEnt(dirName, baseName string, typ os.FileMode) error {
-	joined := dirName + string(os.PathSeparator) + baseName
+// release releases the parent handles of items that will never be walked.
+func (w *walker) release(todo []walkItem) {
+	for _, it := range todo {
+		it.parent.release()
+	}
+	for {
+		select {
+		case it := <-w.workc:
+			it.parent.release()
+		case it := <-w.enqueuec:
+			it.parent.release()
+		default:
+			return
+		}
+	}
+}
+
+func (w *walker) onDirEnt(h *dirHandle, depth int, dirName, baseName string, typ os.FileMode) error {
+	joined := joinPath(dirName, baseName)
 	if typ == os.ModeDir {
-		w.enqueue(walkItem{dir: joined})
-		return nil
+		it := walkItem{dir: joined, parentDir: dirName, name: baseName, depth: depth}
+		if w.order == BreadthFirst {
+			// Call back now, while this depth is being delivered.
+			err := w.fn(Entry{Path: joined, Dir: dirName, Name: baseName, Type: typ, Depth: depth})
+			if err == filepath.SkipDir {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			it.callbackDone = true
+		}
+		it.parent = h.retain()
+		return w.enqueue(it)
 	}
 
-	err := w.fn(joined, typ)
+	err := w.fn(Entry{Path: joined, Dir: dirName, Name: baseName, Type: typ, Depth: depth})
 	if typ == os.ModeSymlink {
 		if err == TraverseLink {
 			// Set callbackDone so we don't call it twice for both the
 			// symlink-as-symlink and the symlink-as-directory later:
-			w.enqueue(walkItem{dir: joined, callbackDone: true})
-			return nil
+			return w.enqueue(walkItem{dir: joined, parent: h.retain(), parentDir: dirName, name: baseName, depth: depth, link: true, callbackDone: true})
 		}
 		if err == filepath.SkipDir {
 			// Permit SkipDir on symlinks too.
@@ -194,16 +388,51 @@ func (w *walker) onDirEnt(dirName, baseName string, typ os.FileMode) error {
 	}
 	return err
 }
-func (w *walker) walk(root string, runUserCallback bool) error {
-	if runUserCallback {
-		err := w.fn(root, os.ModeDir)
-		if err == filepath.SkipDir {
-			return nil
-		}
+
+// joinPath joins a directory and the name of one of its entries.
+func joinPath(dirName, baseName string) string {
+	if os.IsPathSeparator(dirName[len(dirName)-1]) {
+		// dirName is a root such as "/".
+		return dirName + baseName
+	}
+	return dirName + string(os.PathSeparator) + baseName
+}
+
+func (w *walker) walk(it walkItem) error {
+	if !it.callbackDone {
+		err := w.fn(Entry{Path: it.dir, Dir: it.parentDir, Name: it.name, Type: os.ModeDir, Depth: it.depth})
 		if err != nil {
+			it.parent.release()
+			if err == filepath.SkipDir {
+				return nil
+			}
 			return err
 		}
 	}
 
-	return readDir(root, w.onDirEnt)
+	h, err := openDir(it)
+	if err == nil && w.xdev && it.depth > 0 {
+		if dev, ok := h.device(); ok && dev != w.dev {
+			h.release()
+			return nil
+		}
+	}
+	if err == nil {
+		var fnErr error
+		buf := w.bufs.Get().(*[]byte)
+		err = h.readDir(it.dir, *buf, w.filter, func(dirName, baseName string, typ os.FileMode) error {
+			fnErr = w.onDirEnt(h, it.depth+1, dirName, baseName, typ)
+			return fnErr
+		})
+		w.bufs.Put(buf)
+		h.release()
+		if fnErr != nil {
+			return err
+		}
+	}
+	if err != nil && w.onError != nil {
+		// The directory itself could not be read.
+		return w.onError(it.dir, err)
+	}
+	return err
 }
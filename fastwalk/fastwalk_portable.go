@@ -2,28 +2,59 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build appengine !linux,!darwin,!freebsd,!openbsd,!netbsd
+// +build appengine fastwalk_portable !linux,!darwin,!freebsd,!openbsd,!netbsd
 
 package fastwalk
 
 import (
-	"io/ioutil"
+	"errors"
 	"os"
 )
 
 // readDir calls fn for each directory entry in dirName.
 // It does not descend into directories or follow symlinks.
 // If fn returns a non-nil error, readDir returns with that error
-// immediately.
+// immediately. If dirName cannot be read, readDir calls fn for the
+// entries read so far and returns an *os.PathError.
 func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) error) error {
-	fis, err := ioutil.ReadDir(dirName)
-	if err != nil {
-		return nil
-	}
-	for _, fi := range fis {
-		if err := fn(dirName, fi.Name(), fi.Mode()&os.ModeType); err != nil {
+	des, err := os.ReadDir(dirName)
+	for _, de := range des {
+		if err := fn(dirName, de.Name(), de.Type()); err != nil {
 			return err
 		}
 	}
+	if err != nil {
+		var perr *os.PathError
+		if !errors.As(err, &perr) {
+			err = &os.PathError{Op: "readdir", Path: dirName, Err: err}
+		}
+		return err
+	}
 	return nil
 }
+
+// dirHandle is a no-op stand-in for the open directory used by the Unix
+// backend; the portable backend always opens directories by path.
+type dirHandle struct{}
+
+func openDir(it walkItem) (*dirHandle, error) { return nil, nil }
+
+func (h *dirHandle) retain() *dirHandle { return nil }
+
+func (h *dirHandle) release() {}
+
+func (h *dirHandle) device() (uint64, bool) { return 0, false }
+
+func fileDevice(fi os.FileInfo) (uint64, bool) { return 0, false }
+
+func (h *dirHandle) readDir(dirName string, buf []byte, filter func(name []byte, typ os.FileMode) bool, fn func(dirName, entName string, typ os.FileMode) error) error {
+	if filter == nil {
+		return readDir(dirName, fn)
+	}
+	return readDir(dirName, func(dirName, entName string, typ os.FileMode) error {
+		if !filter([]byte(entName), typ) {
+			return nil
+		}
+		return fn(dirName, entName, typ)
+	})
+}
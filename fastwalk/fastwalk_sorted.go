@@ -0,0 +1,193 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fastwalk
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A listing is the sorted contents of one directory, read ahead by a
+// worker for the goroutine delivering the Sorted walk.
+type listing struct {
+	dir       string
+	depth     int
+	link      bool
+	cancelled int32 // skipped by the callback; don't bother reading it
+	done      chan struct{}
+
+	ents []listingEnt
+	err  error
+}
+
+type listingEnt struct {
+	name string
+	typ  os.FileMode
+}
+
+// readAhead is a stack of listings waiting for a worker. The most
+// recently scheduled listing is read first, which is the next one a
+// depth-first walk needs.
+type readAhead struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	stack  []*listing
+	closed bool
+}
+
+func (r *readAhead) push(l *listing) {
+	r.mu.Lock()
+	r.stack = append(r.stack, l)
+	r.mu.Unlock()
+	r.cond.Signal()
+}
+
+// pop returns the next listing to read, or nil once r is closed.
+func (r *readAhead) pop() *listing {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.stack) == 0 && !r.closed {
+		r.cond.Wait()
+	}
+	if r.closed {
+		return nil
+	}
+	l := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	return l
+}
+
+func (r *readAhead) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.stack = nil
+	r.mu.Unlock()
+	r.cond.Broadcast()
+}
+
+// walkSorted implements the Sorted order: numWorkers goroutines read
+// directories ahead while the calling goroutine makes every callback.
+func (w *walker) walkSorted(root string, numWorkers int) error {
+	err := w.fn(Entry{Path: root, Dir: filepath.Dir(root), Name: filepath.Base(root), Type: os.ModeDir})
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r := &readAhead{}
+	r.cond = sync.NewCond(&r.mu)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := r.pop(); l != nil; l = r.pop() {
+				if atomic.LoadInt32(&l.cancelled) == 0 {
+					w.readListing(l)
+				}
+				close(l.done)
+			}
+		}()
+	}
+	defer func() {
+		r.close()
+		wg.Wait()
+	}()
+
+	l := &listing{dir: root, done: make(chan struct{})}
+	r.push(l)
+	return w.deliver(r, l)
+}
+
+// readListing reads and sorts the entries of l.
+func (w *walker) readListing(l *listing) {
+	h, err := openDir(walkItem{dir: l.dir, link: l.link})
+	if err != nil {
+		l.err = err
+		return
+	}
+	defer h.release()
+	if w.xdev && l.depth > 0 {
+		if dev, ok := h.device(); ok && dev != w.dev {
+			return
+		}
+	}
+	buf := w.bufs.Get().(*[]byte)
+	l.err = h.readDir(l.dir, *buf, w.filter, func(dirName, baseName string, typ os.FileMode) error {
+		l.ents = append(l.ents, listingEnt{name: baseName, typ: typ})
+		return nil
+	})
+	w.bufs.Put(buf)
+	sort.Slice(l.ents, func(i, j int) bool { return l.ents[i].name < l.ents[j].name })
+}
+
+// deliver makes the callbacks for the entries of l and, depth first,
+// everything below them. A subdirectory is only read once the callback
+// has entered it, except for the next one, which is read ahead while the
+// current one is delivered.
+func (w *walker) deliver(r *readAhead, l *listing) error {
+	<-l.done
+	if l.err != nil {
+		if w.onError != nil {
+			return w.onError(l.dir, l.err)
+		}
+		return l.err
+	}
+
+	// ahead is the next subdirectory after the last one entered.
+	var ahead *listing
+	cancel := func() {
+		if ahead != nil {
+			atomic.StoreInt32(&ahead.cancelled, 1)
+			ahead = nil
+		}
+	}
+
+	for i, ent := range l.ents {
+		joined := joinPath(l.dir, ent.name)
+		err := w.fn(Entry{Path: joined, Dir: l.dir, Name: ent.name, Type: ent.typ, Depth: l.depth + 1})
+		switch {
+		case ent.typ == os.ModeDir && err == filepath.SkipDir:
+			cancel()
+			continue
+		case ent.typ == os.ModeDir && err == nil:
+			sub := ahead
+			ahead = nil
+			for _, next := range l.ents[i+1:] {
+				if next.typ == os.ModeDir {
+					ahead = &listing{dir: joinPath(l.dir, next.name), depth: l.depth + 1, done: make(chan struct{})}
+					r.push(ahead)
+					break
+				}
+			}
+			if sub == nil {
+				// Pushed last, so it is read before ahead.
+				sub = &listing{dir: joined, depth: l.depth + 1, done: make(chan struct{})}
+				r.push(sub)
+			}
+			err = w.deliver(r, sub)
+		case ent.typ == os.ModeSymlink && err == TraverseLink:
+			sub := &listing{dir: joined, depth: l.depth + 1, link: true, done: make(chan struct{})}
+			r.push(sub)
+			err = w.deliver(r, sub)
+		case ent.typ == os.ModeSymlink && err == filepath.SkipDir:
+			continue
+		case err == filepath.SkipDir:
+			// As with filepath.Walk, skip the rest of the directory.
+			cancel()
+			return nil
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,18 @@
 package fastwalk
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestFastWalk(t *testing.T) {
@@ -57,3 +65,398 @@ func TestFastWalk(t *testing.T) {
 		}
 	}
 }
+
+func TestFastWalkStop(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, d := range []string{"a/b/c", "d/e/f", "g/h/i"} {
+		os.MkdirAll(filepath.Join(tmpdir, d), 0755)
+		ioutil.WriteFile(filepath.Join(tmpdir, d, "x.txt"), []byte{}, 0644)
+	}
+
+	before := runtime.NumGoroutine()
+	stop := errors.New("stop")
+	for i := 0; i < 20; i++ {
+		err = FastWalk(tmpdir, func(path string, mode os.FileMode) error {
+			if !mode.IsDir() {
+				return stop
+			}
+			return nil
+		})
+		if err != stop {
+			t.Fatalf("expected %v but got %v", stop, err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("expected at most %d goroutines after stopping but got %d", before, n)
+	}
+}
+
+func TestFastWalkOnError(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	missing := filepath.Join(tmpdir, "missing")
+	var got []string
+	err = FastWalkWithOptions(missing, func(path string, mode os.FileMode) error {
+		return nil
+	}, Options{OnError: func(path string, err error) error {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("expected *os.PathError but got %T", err)
+		}
+		got = append(got, path)
+		return nil
+	}})
+	if err != nil {
+		t.Errorf("expected OnError to swallow the error but got %v", err)
+	}
+	if len(got) != 1 || got[0] != missing {
+		t.Errorf("expected OnError to be called with %q but got %v", missing, got)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	os.MkdirAll(filepath.Join(tmpdir, "a/locked"), 0755)
+	ioutil.WriteFile(filepath.Join(tmpdir, "a/x.txt"), []byte{}, 0644)
+	os.Chmod(filepath.Join(tmpdir, "a/locked"), 0)
+	defer os.Chmod(filepath.Join(tmpdir, "a/locked"), 0755)
+
+	got = nil
+	found := false
+	err = FastWalkWithOptions(tmpdir, func(path string, mode os.FileMode) error {
+		if filepath.Base(path) == "x.txt" {
+			found = true
+		}
+		return nil
+	}, Options{OnError: func(path string, err error) error {
+		got = append(got, path)
+		return nil
+	}})
+	if err != nil {
+		t.Errorf("expected OnError to swallow the error but got %v", err)
+	}
+	if len(got) != 1 || got[0] != filepath.Join(tmpdir, "a/locked") {
+		t.Errorf("expected OnError to be called for the locked directory but got %v", got)
+	}
+	if !found {
+		t.Errorf("expected the walk to continue past the locked directory")
+	}
+}
+
+func TestReadDirError(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	missing := filepath.Join(tmpdir, "missing")
+	err = readDir(missing, func(dirName, entName string, typ os.FileMode) error {
+		t.Errorf("unexpected entry %q", entName)
+		return nil
+	})
+	perr, ok := err.(*os.PathError)
+	if !ok {
+		t.Fatalf("expected *os.PathError but got %#v", err)
+	}
+	if perr.Path != missing || !os.IsNotExist(perr.Err) {
+		t.Errorf("expected not-exist error for %q but got %v", missing, err)
+	}
+}
+
+func TestFastWalkOptions(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	want := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			dir := filepath.Join(tmpdir, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j))
+			os.MkdirAll(dir, 0755)
+			ioutil.WriteFile(filepath.Join(dir, "x.txt"), []byte{}, 0644)
+			want[filepath.Join(dir, "x.txt")] = true
+		}
+	}
+
+	for _, opts := range []Options{
+		{Workers: 1},
+		{Workers: 1, MaxQueued: 1},
+		{Workers: 8, MaxQueued: 2},
+		{MaxQueued: 1},
+	} {
+		var mu sync.Mutex
+		var active, maxActive int
+		got := map[string]bool{}
+		err := FastWalkWithOptions(tmpdir, func(path string, mode os.FileMode) error {
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			if !mode.IsDir() {
+				got[path] = true
+			}
+			mu.Unlock()
+			time.Sleep(time.Microsecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return nil
+		}, opts)
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%+v: expected %d files but got %d", opts, len(want), len(got))
+		}
+		if opts.Workers > 0 && maxActive > opts.Workers {
+			t.Errorf("%+v: expected at most %d concurrent callbacks but got %d", opts, opts.Workers, maxActive)
+		}
+	}
+}
+
+func TestWalkEntry(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	os.MkdirAll(filepath.Join(tmpdir, "foo/bar"), 0755)
+	ioutil.WriteFile(filepath.Join(tmpdir, "foo/bar/baz.txt"), []byte{}, 0644)
+
+	expected := map[string]Entry{
+		"":                {Dir: filepath.Dir(tmpdir), Name: filepath.Base(tmpdir), Type: os.ModeDir, Depth: 0},
+		"foo":             {Dir: tmpdir, Name: "foo", Type: os.ModeDir, Depth: 1},
+		"foo/bar":         {Dir: filepath.Join(tmpdir, "foo"), Name: "bar", Type: os.ModeDir, Depth: 2},
+		"foo/bar/baz.txt": {Dir: filepath.Join(tmpdir, "foo/bar"), Name: "baz.txt", Type: 0, Depth: 3},
+	}
+	var mu sync.Mutex
+	got := map[string]Entry{}
+	err = Walk(tmpdir, func(e Entry) error {
+		rel, err := filepath.Rel(tmpdir, e.Path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		if e.Path != filepath.Join(e.Dir, e.Name) {
+			t.Errorf("Path %q is not Dir %q joined with Name %q", e.Path, e.Dir, e.Name)
+		}
+		e.Path = ""
+		mu.Lock()
+		got[filepath.ToSlash(rel)] = e
+		mu.Unlock()
+		return nil
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+}
+
+func TestFastWalkFilter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	os.MkdirAll(filepath.Join(tmpdir, "keep/skip"), 0755)
+	os.MkdirAll(filepath.Join(tmpdir, "skip"), 0755)
+	ioutil.WriteFile(filepath.Join(tmpdir, "keep/a.txt"), []byte{}, 0644)
+	ioutil.WriteFile(filepath.Join(tmpdir, "keep/b.go"), []byte{}, 0644)
+	ioutil.WriteFile(filepath.Join(tmpdir, "skip/c.txt"), []byte{}, 0644)
+
+	for _, size := range []int{0, 1, 64 << 10} {
+		var mu sync.Mutex
+		got := map[string]bool{}
+		err = FastWalkWithOptions(tmpdir, func(path string, mode os.FileMode) error {
+			rel, _ := filepath.Rel(tmpdir, path)
+			mu.Lock()
+			got[filepath.ToSlash(rel)] = true
+			mu.Unlock()
+			return nil
+		}, Options{
+			BufferSize: size,
+			Filter: func(name []byte, typ os.FileMode) bool {
+				if typ.IsDir() {
+					return string(name) != "skip"
+				}
+				return filepath.Ext(string(name)) == ".txt"
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]bool{".": true, "keep": true, "keep/a.txt": true}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("BufferSize %d: expected %v but got %v", size, expected, got)
+		}
+	}
+}
+
+func BenchmarkFastWalkHugeDir(b *testing.B) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for i := 0; i < 20000; i++ {
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, fmt.Sprintf("file-%06d.dat", i)), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	noop := func(path string, typ os.FileMode) error { return nil }
+	rejectAll := func(name []byte, typ os.FileMode) bool { return false }
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{"8KiB", Options{BufferSize: 8 << 10}},
+		{"32KiB", Options{}},
+		{"256KiB", Options{BufferSize: 256 << 10}},
+		{"Filter", Options{Filter: rejectAll}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := FastWalkWithOptions(tmpdir, noop, bc.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, dir := range []string{"b/c/d", "a/x", "a/b", "c", "B"} {
+		os.MkdirAll(filepath.Join(tmpdir, dir), 0755)
+	}
+	for _, file := range []string{"z.txt", "a/x/1.txt", "a/0.txt", "b/c/d/e.txt", "c/f.txt"} {
+		ioutil.WriteFile(filepath.Join(tmpdir, file), []byte{}, 0644)
+	}
+
+	for _, workers := range []int{1, 4} {
+		var mu sync.Mutex
+		var depths []int
+		err = Walk(tmpdir, func(e Entry) error {
+			mu.Lock()
+			depths = append(depths, e.Depth)
+			mu.Unlock()
+			if e.Name == "c" && e.Depth == 1 {
+				return filepath.SkipDir
+			}
+			return nil
+		}, Options{Workers: workers, Order: BreadthFirst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(depths) != 13 {
+			t.Errorf("BreadthFirst: expected 13 entries but got %d", len(depths))
+		}
+		for i := 1; i < len(depths); i++ {
+			if depths[i] < depths[i-1] {
+				t.Errorf("BreadthFirst: depth went from %d to %d in %v", depths[i-1], depths[i], depths)
+				break
+			}
+		}
+
+		var expected []string
+		filepath.Walk(tmpdir, func(path string, info os.FileInfo, err error) error {
+			expected = append(expected, path)
+			if info.Name() == "c" && filepath.Dir(path) == tmpdir {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		var got []string
+		err = Walk(tmpdir, func(e Entry) error {
+			got = append(got, e.Path)
+			if e.Name == "c" && e.Depth == 1 {
+				return filepath.SkipDir
+			}
+			return nil
+		}, Options{Workers: workers, Order: Sorted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Sorted: expected %v but got %v", expected, got)
+		}
+	}
+}
+
+func TestWalkSortedSkipDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "fastwalk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for i := 0; i < 20; i++ {
+		dir := filepath.Join(tmpdir, fmt.Sprintf("s%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("in%02d", i)), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 4} {
+		var mu sync.Mutex
+		var read []string
+		err = Walk(tmpdir, func(e Entry) error {
+			if e.Type == os.ModeDir && e.Depth == 1 && e.Name != "s00" {
+				return filepath.SkipDir
+			}
+			return nil
+		}, Options{
+			Workers: workers,
+			Order:   Sorted,
+			Filter: func(name []byte, typ os.FileMode) bool {
+				if strings.HasPrefix(string(name), "in") {
+					mu.Lock()
+					read = append(read, string(name))
+					mu.Unlock()
+				}
+				return true
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// s01 may be read ahead while s00 is delivered.
+		sort.Strings(read)
+		if len(read) == 0 || read[0] != "in00" || len(read) > 2 {
+			t.Errorf("workers %d: expected only s00 and s01 to be read but got %v", workers, read)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 // license that can be found in the LICENSE file.
 
 // +build linux,!appengine darwin freebsd openbsd netbsd
+// +build !fastwalk_portable
 
 package fastwalk
 
@@ -10,33 +11,129 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
-const blockSize = 8 << 10
+// minBufferSize is the smallest buffer passed to getdents; it must be at
+// least a block long.
+const minBufferSize = 4 << 10
+
+// maxOpenHandles bounds the number of directory descriptors kept open so
+// that queued subdirectories can be opened relative to their parent.
+// Beyond it, subdirectories are opened by their full path.
+const maxOpenHandles = 128
 
 // unknownFileMode is a sentinel (and bogus) os.FileMode
 // value used to represent a syscall.DT_UNKNOWN Dirent.Type.
 const unknownFileMode os.FileMode = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice
 
+var (
+	openHandles int32 // directory descriptors currently open
+	useOpenat   = true
+)
+
+// dirHandle is an open directory. It is shared, by reference count,
+// between the worker reading it and its queued subdirectories, which are
+// opened relative to it with openat. This saves the kernel from resolving
+// the full path again for every directory and keeps the walk inside the
+// directory that was read even if one of its ancestors is renamed.
+type dirHandle struct {
+	fd   int
+	refs int32
+}
+
+// openDir opens the directory of it, relative to its parent handle if it
+// has one, and releases the parent.
+func openDir(it walkItem) (*dirHandle, error) {
+	flags := unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC
+	var fd int
+	var err error
+	if it.parent != nil {
+		if !it.link {
+			// A plain directory entry; refuse to follow it if it has
+			// been replaced by a symlink since it was read.
+			flags |= unix.O_NOFOLLOW
+		}
+		fd, err = unix.Openat(it.parent.fd, it.name, flags, 0)
+		it.parent.release()
+	} else {
+		fd, err = unix.Open(it.dir, flags, 0)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: it.dir, Err: err}
+	}
+	atomic.AddInt32(&openHandles, 1)
+	return &dirHandle{fd: fd, refs: 1}, nil
+}
+
+// retain returns h with an extra reference for a subdirectory to be
+// opened relative to it, or nil if too many directories are open.
+func (h *dirHandle) retain() *dirHandle {
+	if h == nil || !useOpenat || atomic.LoadInt32(&openHandles) >= maxOpenHandles {
+		return nil
+	}
+	atomic.AddInt32(&h.refs, 1)
+	return h
+}
+
+// release drops a reference to h, closing it when none are left.
+func (h *dirHandle) release() {
+	if h == nil {
+		return
+	}
+	if atomic.AddInt32(&h.refs, -1) == 0 {
+		unix.Close(h.fd)
+		atomic.AddInt32(&openHandles, -1)
+	}
+}
+
 func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) error) error {
-	fd, err := syscall.Open(dirName, 0, 0)
+	h, err := openDir(walkItem{dir: dirName})
 	if err != nil {
 		return err
 	}
-	defer syscall.Close(fd)
+	defer h.release()
+	return h.readDir(dirName, make([]byte, defaultBufferSize), nil, fn)
+}
+
+// device returns the ID of the device h is on.
+func (h *dirHandle) device() (uint64, bool) {
+	var st unix.Stat_t
+	if err := unix.Fstat(h.fd, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// fileDevice returns the ID of the device fi is on.
+func fileDevice(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
 
-	// The buffer must be at least a block long.
-	buf := make([]byte, blockSize) // stack-allocated; doesn't escape
-	bufp := 0                      // starting read position in buf
-	nbuf := 0                      // end valid data in buf
+// readDir calls fn for each entry of the directory h, named dirName,
+// reading entries into buf. If filter is not nil, entries it rejects are
+// skipped before their name is converted to a string.
+func (h *dirHandle) readDir(dirName string, buf []byte, filter func(name []byte, typ os.FileMode) bool, fn func(dirName, entName string, typ os.FileMode) error) error {
+	if len(buf) < minBufferSize {
+		buf = make([]byte, minBufferSize)
+	}
+	bufp := 0 // starting read position in buf
+	nbuf := 0 // end valid data in buf
+	var err error
 	for {
 		if bufp >= nbuf {
 			bufp = 0
-			nbuf, err = syscall.ReadDirent(fd, buf)
+			nbuf, err = syscall.ReadDirent(h.fd, buf)
 			if err != nil {
-				return os.NewSyscallError("readdirent", err)
+				return &os.PathError{Op: "readdirent", Path: dirName, Err: err}
 			}
 			if nbuf <= 0 {
 				return nil
@@ -44,30 +141,59 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 		}
 		consumed, name, typ := parseDirEnt(buf[bufp:nbuf])
 		bufp += consumed
-		if name == "" || name == "." || name == ".." {
+		if len(name) == 0 || isDot(name) {
 			continue
 		}
 		// Fallback for filesystems (like old XFS) that don't
 		// support Dirent.Type and have DT_UNKNOWN (0) there
 		// instead.
 		if typ == unknownFileMode {
-			fi, err := os.Lstat(dirName + "/" + name)
-			if err != nil {
+			var st unix.Stat_t
+			if err := unix.Fstatat(h.fd, string(name), &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
 				// It got deleted in the meantime.
-				if os.IsNotExist(err) {
+				if err == unix.ENOENT {
 					continue
 				}
-				return err
+				return &os.PathError{Op: "fstatat", Path: dirName + "/" + string(name), Err: err}
 			}
-			typ = fi.Mode() & os.ModeType
+			typ = statFileMode(uint32(st.Mode))
 		}
-		if err := fn(dirName, name, typ); err != nil {
+		if filter != nil && !filter(name, typ) {
+			continue
+		}
+		if err := fn(dirName, string(name), typ); err != nil {
 			return err
 		}
 	}
 }
 
-func parseDirEnt(buf []byte) (consumed int, name string, typ os.FileMode) {
+// statFileMode returns the type bits of a stat mode.
+func statFileMode(mode uint32) os.FileMode {
+	switch mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		return os.ModeDir
+	case unix.S_IFLNK:
+		return os.ModeSymlink
+	case unix.S_IFBLK:
+		return os.ModeDevice
+	case unix.S_IFCHR:
+		return os.ModeDevice | os.ModeCharDevice
+	case unix.S_IFIFO:
+		return os.ModeNamedPipe
+	case unix.S_IFSOCK:
+		return os.ModeSocket
+	}
+	return 0
+}
+
+// isDot reports whether name is "." or "..".
+func isDot(name []byte) bool {
+	return name[0] == '.' && (len(name) == 1 || len(name) == 2 && name[1] == '.')
+}
+
+// parseDirEnt parses the dirent at the start of buf. The returned name
+// aliases buf.
+func parseDirEnt(buf []byte) (consumed int, name []byte, typ os.FileMode) {
 	// golang.org/issue/15653
 	dirent := (*syscall.Dirent)(unsafe.Pointer(&buf[0]))
 	if v := unsafe.Offsetof(dirent.Reclen) + unsafe.Sizeof(dirent.Reclen); uintptr(len(buf)) < v {
@@ -104,19 +230,13 @@ func parseDirEnt(buf []byte) (consumed int, name string, typ os.FileMode) {
 		return
 	}
 
-	nameBuf := (*[unsafe.Sizeof(dirent.Name)]byte)(unsafe.Pointer(&dirent.Name[0]))
-	nameLen := bytes.IndexByte(nameBuf[:], 0)
+	// Only look within the record: the Name array of the last dirent in
+	// buf may extend past its end.
+	nameBuf := buf[unsafe.Offsetof(dirent.Name):consumed]
+	nameLen := bytes.IndexByte(nameBuf, 0)
 	if nameLen < 0 {
 		panic("failed to find terminating 0 byte in dirent")
 	}
-
-	// Special cases for common things:
-	if nameLen == 1 && nameBuf[0] == '.' {
-		name = "."
-	} else if nameLen == 2 && nameBuf[0] == '.' && nameBuf[1] == '.' {
-		name = ".."
-	} else {
-		name = string(nameBuf[:nameLen])
-	}
+	name = nameBuf[:nameLen:nameLen]
 	return
 }
@@ -0,0 +1,147 @@
+// +build linux,!appengine darwin freebsd openbsd netbsd
+// +build !fastwalk_portable
+
+package fastwalk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func makeDeepTree(tb testing.TB, depth, fanout int) string {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var mk func(dir string, depth int)
+	mk = func(dir string, depth int) {
+		if depth == 0 {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			sub := filepath.Join(dir, strings.Repeat(string(rune('a'+i)), 8))
+			if err := os.Mkdir(sub, 0755); err != nil {
+				tb.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(sub, "file.txt"), []byte{}, 0644); err != nil {
+				tb.Fatal(err)
+			}
+			mk(sub, depth-1)
+		}
+	}
+	mk(tmpdir, depth)
+	return tmpdir
+}
+
+func TestFastWalkReleasesHandles(t *testing.T) {
+	tmpdir := makeDeepTree(t, 6, 3)
+	defer os.RemoveAll(tmpdir)
+
+	var n int32
+	err := FastWalk(tmpdir, func(path string, mode os.FileMode) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int32(1 + 2*(3+9+27+81+243+729)); n != want {
+		t.Errorf("expected %d entries but got %d", want, n)
+	}
+	if h := atomic.LoadInt32(&openHandles); h != 0 {
+		t.Errorf("expected all directory handles to be closed but %d are open", h)
+	}
+
+	stop := errors.New("stop")
+	for i := 0; i < 20; i++ {
+		var seen int32
+		err = FastWalk(tmpdir, func(path string, mode os.FileMode) error {
+			if atomic.AddInt32(&seen, 1) > 50 {
+				return stop
+			}
+			if mode.IsDir() && strings.HasSuffix(path, "bbbbbbbb") {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != stop {
+			t.Fatalf("expected %v but got %v", stop, err)
+		}
+	}
+	if h := atomic.LoadInt32(&openHandles); h != 0 {
+		t.Errorf("expected all directory handles to be closed but %d are open", h)
+	}
+}
+
+func benchmarkFastWalkDeep(b *testing.B, openat bool) {
+	// A single chain of 40 directories: the deeper a directory, the
+	// longer the path the kernel has to resolve to open it by name.
+	tmpdir := makeDeepTree(b, 40, 1)
+	defer os.RemoveAll(tmpdir)
+
+	defer func(v bool) { useOpenat = v }(useOpenat)
+	useOpenat = openat
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := FastWalk(tmpdir, func(path string, mode os.FileMode) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFastWalkDeepOpenat(b *testing.B) { benchmarkFastWalkDeep(b, true) }
+
+func BenchmarkFastWalkDeepPath(b *testing.B) { benchmarkFastWalkDeep(b, false) }
+
+func TestFastWalkSameDevice(t *testing.T) {
+	root, err := os.Stat("/")
+	if err != nil {
+		t.Skip(err)
+	}
+	proc, err := os.Stat("/proc")
+	if err != nil {
+		t.Skip(err)
+	}
+	rootDev, _ := fileDevice(root)
+	if dev, _ := fileDevice(proc); dev == rootDev {
+		t.Skip("/proc is on the root device")
+	}
+
+	for _, xdev := range []bool{false, true} {
+		var inside int32
+		err := FastWalkWithOptions("/", func(path string, mode os.FileMode) error {
+			path = filepath.Clean(path)
+			if path == "/" || path == "/proc" {
+				return nil
+			}
+			if !strings.HasPrefix(path, "/proc/") {
+				if mode.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			atomic.AddInt32(&inside, 1)
+			if mode.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}, Options{SameDevice: xdev, OnError: func(string, error) error { return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if xdev && inside != 0 {
+			t.Errorf("SameDevice: expected no entries under /proc but got %d", inside)
+		}
+		if !xdev && inside == 0 {
+			t.Errorf("expected entries under /proc")
+		}
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/mattn/go-zglob
 
-go 1.12
+go 1.18
+
+require (
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.14.0
+)
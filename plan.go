@@ -0,0 +1,325 @@
+package zglob
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/mattn/go-zglob/fastwalk"
+)
+
+// maxRoots bounds the number of start directories or literal paths planned
+// for a pattern; beyond it the pattern is walked from its common root
+// instead.
+const maxRoots = 64
+
+// roots returns the directories Glob walks for z. When the first wildcard
+// of the pattern is a brace, as in {src,test}/**/*.go, each alternative is
+// expanded and contributes its own literal prefix, so only src and test are
+// walked instead of the whole current directory. Roots nested in other
+// roots are dropped. The second result reports whether the roots were
+// planned this way; if not, roots is just the root of z.
+func (z *Pattern) roots() ([]string, bool) {
+	if i := firstMeta(z.pattern); i < 0 || z.pattern[i] != '{' {
+		return []string{z.root}, false
+	}
+	var alts []string
+	if !expandBraces(z.pattern, &alts) {
+		return []string{z.root}, false
+	}
+	abs := filepath.IsAbs(z.pattern)
+	planned := make([]string, 0, len(alts))
+	for _, alt := range alts {
+		if i := firstMeta(alt); i >= 0 {
+			alt = alt[:i]
+		}
+		r := literalDir(alt)
+		if filepath.IsAbs(r) != abs {
+			return []string{z.root}, false
+		}
+		planned = append(planned, r)
+	}
+
+	sort.Strings(planned)
+	var roots []string
+	for _, r := range planned {
+		nested := false
+		for _, k := range roots {
+			if r == k || k == "." || strings.HasPrefix(r, strings.TrimSuffix(k, string(os.PathSeparator))+string(os.PathSeparator)) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			roots = append(roots, r)
+		}
+	}
+	return roots, true
+}
+
+// firstMeta returns the index of the first wildcard or escape in pattern,
+// or -1 if it is literal.
+func firstMeta(pattern string) int {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[', '{', '\\':
+			return i
+		case '!':
+			if i+1 < len(pattern) && pattern[i+1] == '(' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// literalDir returns the directory holding the last segment of the
+// slash-separated prefix.
+func literalDir(prefix string) string {
+	i := strings.LastIndexByte(prefix, '/')
+	switch {
+	case i < 0:
+		return "."
+	case i == 0:
+		return string(os.PathSeparator)
+	}
+	dir := prefix[:i]
+	if runtime.GOOS == "windows" && filepath.VolumeName(dir) == dir {
+		dir += "/"
+	}
+	return filepath.Clean(dir)
+}
+
+// literals returns the paths named by a pattern whose only wildcards are
+// braces, such as a/{b,c}/d.txt, so that Glob can Lstat them instead of
+// walking. It reports false for any other pattern, and when names are
+// normalized, since the names on disk may then differ from the pattern.
+func (z *Pattern) literals() ([]string, bool) {
+	var alts []string
+	if z.opts.normalization != NoNormalization || !expandBraces(z.pattern, &alts) {
+		return nil, false
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	for _, alt := range alts {
+		if firstMeta(alt) >= 0 {
+			return nil, false
+		}
+		alt = path.Clean(alt)
+		if !seen[alt] {
+			seen[alt] = true
+			paths = append(paths, alt)
+		}
+	}
+	if z.opts.order == fastwalk.Sorted {
+		sort.Slice(paths, func(i, j int) bool { return walksBefore(paths[i], paths[j]) })
+	}
+	return paths, true
+}
+
+// walksBefore reports whether a Sorted walk reaches the slash-separated
+// path a before b: segment by segment, with a directory before its
+// contents.
+func walksBefore(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// expandBraces appends to out every expansion of the braces in pattern
+// up to its first other wildcard or escape, so that {a,b}/*/{c,d} yields
+// a/*/{c,d} and b/*/{c,d}. It reports false if a brace can't be expanded
+// or there are more than maxRoots expansions.
+func expandBraces(pattern string, out *[]string) bool {
+	i := firstMeta(pattern)
+	if i < 0 || pattern[i] != '{' {
+		*out = append(*out, pattern)
+		return len(*out) <= maxRoots
+	}
+	end := strings.IndexByte(pattern[i:], '}')
+	if end < 0 {
+		return false
+	}
+	end += i
+	for _, alt := range strings.Split(pattern[i+1:end], ",") {
+		if alt == "" || strings.ContainsAny(alt, "{\\") {
+			// Nested or escaped braces are left to the regular expression.
+			return false
+		}
+		if !expandBraces(pattern[:i]+alt+pattern[end+1:], out) {
+			return false
+		}
+	}
+	return true
+}
+
+// readsLevels reports whether Glob can read the directories named by z
+// level by level, like a shell, instead of walking the tree below its
+// root. That is the case when the pattern has no ** and no option needs
+// the walker.
+func (z *Pattern) readsLevels() bool {
+	o := z.opts
+	if z.segs == nil || o.normalization != NoNormalization || o.sameDevice || o.minDepth > 0 || o.maxDepth > 0 {
+		return false
+	}
+	for _, seg := range z.segs {
+		if seg == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// globLevels matches z one segment at a time: literal segments are joined
+// without reading anything, and only the directories holding a wildcard
+// segment are read.
+func (z *Pattern) globLevels(followSymlinks bool, add func(string) error, onError func(string, error) error) error {
+	parts := strings.Split(path.Clean(z.pattern), "/")
+	dirs := []string{""}
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var next []string
+		for _, dir := range dirs {
+			if firstMeta(part) < 0 {
+				p := joinSegment(dir, part, i)
+				if !last {
+					if !followSymlinks && i >= z.rootDepth() && z.isLink(p) {
+						continue
+					}
+					next = append(next, p)
+					continue
+				}
+				if err := z.lstat(p, add, onError); err != nil {
+					return err
+				}
+				continue
+			}
+
+			z.trace("read %s", readableDir(dir, i))
+			ents, err := os.ReadDir(z.fsPath(readableDir(dir, i)))
+			if err != nil {
+				if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+					continue
+				}
+				if err = onError(readableDir(dir, i), err); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, ent := range ents {
+				name := ent.Name()
+				if z.opts.byteExact {
+					name = latin1(name)
+				}
+				if !z.segs[i].MatchString(name) {
+					continue
+				}
+				p := joinSegment(dir, ent.Name(), i)
+				typ := ent.Type()
+				if last {
+					if z.matchName(p) && z.matchType(typ) {
+						if err := add(p); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				if typ.IsDir() {
+					next = append(next, p)
+				} else if followSymlinks && typ == os.ModeSymlink {
+					if fi, err := os.Stat(z.fsPath(p)); err == nil && fi.IsDir() {
+						next = append(next, p)
+					}
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		dirs = next
+	}
+	return nil
+}
+
+// lstat adds the literal path p if it matches z, exists and has a wanted
+// type.
+func (z *Pattern) lstat(p string, add func(string) error, onError func(string, error) error) error {
+	if !z.matchName(p) {
+		return nil
+	}
+	fi, err := os.Lstat(z.fsPath(p))
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil
+		}
+		return onError(p, err)
+	}
+	if !z.matchType(fi.Mode()) {
+		return nil
+	}
+	return add(p)
+}
+
+// rootDepth returns the number of segments in the root of z.
+func (z *Pattern) rootDepth() int {
+	switch root := path.Clean(filepath.ToSlash(z.root)); root {
+	case ".":
+		return 0
+	case "/":
+		return 1
+	default:
+		return strings.Count(root, "/") + 1
+	}
+}
+
+// linkBelowRoot reports whether a directory on the slash-separated path
+// dir, below the root of z, is a symbolic link. The walker only follows
+// those for GlobFollowSymlinks, so the other plans must not either.
+func (z *Pattern) linkBelowRoot(dir string) bool {
+	if dir == "." {
+		return false
+	}
+	parts := strings.Split(dir, "/")
+	for i := z.rootDepth(); i < len(parts); i++ {
+		if z.isLink(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *Pattern) isLink(p string) bool {
+	fi, err := os.Lstat(z.fsPath(p))
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+// joinSegment appends the i-th segment name to the slash-separated dir.
+func joinSegment(dir, name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// readableDir returns the directory to read for the i-th segment of a
+// pattern, given the slash-separated path of the segments before it.
+func readableDir(dir string, i int) string {
+	switch {
+	case i == 0:
+		return "."
+	case dir == "":
+		return "/"
+	case runtime.GOOS == "windows" && filepath.VolumeName(dir) == dir:
+		return dir + "/"
+	}
+	return dir
+}
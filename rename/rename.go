@@ -0,0 +1,225 @@
+// Package rename computes and performs pattern-based mass renames in the
+// style of zsh's zmv.
+//
+// Sources are selected with a zglob pattern and destinations are built
+// from a template in which $1, $2, ... (or ${1}, ${2}, ...) refer to the
+// text matched by each wildcard of the pattern, as returned by
+// zglob.Pattern.Submatch. For example, the pattern `**/*.jpeg` with the
+// template `$1$2.jpg` renames a/b/c.jpeg to a/b/c.jpg.
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-zglob"
+)
+
+var (
+	// ErrCollision is returned when two sources map to the same destination.
+	ErrCollision = errors.New("rename: multiple sources map to the same destination")
+	// ErrExists is returned when a destination already exists and is not
+	// itself renamed away.
+	ErrExists = errors.New("rename: destination already exists")
+)
+
+// Rename is a single source to destination mapping.
+type Rename struct {
+	From string
+	To   string
+}
+
+// Expand substitutes $N and ${N} in template with captures[N-1]. $$
+// yields a literal $.
+func Expand(template string, captures []string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '$' || i == len(template)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if template[i] == '$' {
+			b.WriteByte('$')
+			continue
+		}
+		var num string
+		if template[i] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("rename: unterminated ${ in %q", template)
+			}
+			num = template[i+1 : i+end]
+			i += end
+		} else {
+			j := i
+			for j < len(template) && '0' <= template[j] && template[j] <= '9' {
+				j++
+			}
+			num = template[i:j]
+			i = j - 1
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("rename: invalid reference $%s in %q", num, template)
+		}
+		if n > len(captures) {
+			return "", fmt.Errorf("rename: reference $%d in %q but pattern has %d captures", n, template, len(captures))
+		}
+		b.WriteString(captures[n-1])
+	}
+	return b.String(), nil
+}
+
+// Plan globs pattern and computes the destination of every match from
+// template. Matches whose destination equals their source are dropped.
+// Plan fails with ErrCollision if two sources share a destination, and
+// with ErrExists if a destination exists on disk and is not one of the
+// sources, unless overwrite is true.
+func Plan(pattern, template string, overwrite bool, opts ...zglob.Option) ([]Rename, error) {
+	z, err := zglob.New(pattern, opts...)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := zglob.Glob(pattern, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var renames []Rename
+	for _, m := range matches {
+		captures := z.Submatch(m)
+		if captures == nil {
+			continue
+		}
+		to, err := Expand(template, captures)
+		if err != nil {
+			return nil, err
+		}
+		to = filepath.Clean(filepath.FromSlash(to))
+		from := filepath.Clean(filepath.FromSlash(m))
+		if from == to {
+			continue
+		}
+		renames = append(renames, Rename{From: from, To: to})
+	}
+	if err := Check(renames, overwrite); err != nil {
+		return nil, err
+	}
+	return renames, nil
+}
+
+// Check validates renames for collisions and, unless overwrite is true,
+// for destinations that already exist and are not renamed away.
+func Check(renames []Rename, overwrite bool) error {
+	sources := make(map[string]bool, len(renames))
+	for _, r := range renames {
+		sources[r.From] = true
+	}
+	dests := make(map[string]string, len(renames))
+	for _, r := range renames {
+		if prev, ok := dests[r.To]; ok {
+			return fmt.Errorf("%w: %s and %s -> %s", ErrCollision, prev, r.From, r.To)
+		}
+		dests[r.To] = r.From
+		if overwrite || sources[r.To] {
+			continue
+		}
+		if _, err := os.Lstat(r.To); err == nil {
+			return fmt.Errorf("%w: %s -> %s", ErrExists, r.From, r.To)
+		}
+	}
+	return nil
+}
+
+// Order returns the sequence of renames to perform so that no source is
+// overwritten before it has been moved. Chains such as a->b, b->c are
+// ordered b->c first, and cycles such as a->b, b->a are broken by moving
+// one source to a temporary name in its directory. A source inside
+// another source, such as a/x next to a, is renamed first, while its
+// path is still valid.
+func Order(renames []Rename) ([]Rename, error) {
+	pending := make([]Rename, len(renames))
+	copy(pending, renames)
+	var ordered []Rename
+	for len(pending) > 0 {
+		sources := make(map[string]bool, len(pending))
+		parents := make(map[string]bool)
+		for _, r := range pending {
+			sources[r.From] = true
+			for dir := filepath.Dir(r.From); !parents[dir]; dir = filepath.Dir(dir) {
+				parents[dir] = true
+				if dir == filepath.Dir(dir) {
+					break
+				}
+			}
+		}
+		progressed := false
+		rest := pending[:0]
+		for _, r := range pending {
+			if sources[r.To] || parents[r.From] {
+				rest = append(rest, r)
+				continue
+			}
+			ordered = append(ordered, r)
+			delete(sources, r.From)
+			progressed = true
+		}
+		pending = rest
+		if progressed || len(pending) == 0 {
+			continue
+		}
+
+		// Every remaining destination is still occupied: break the cycle
+		// at a source with nothing else to rename inside it.
+		i := 0
+		for parents[pending[i].From] {
+			i++
+		}
+		tmp, err := tempName(pending[i].From)
+		if err != nil {
+			return nil, err
+		}
+		ordered = append(ordered, Rename{From: pending[i].From, To: tmp})
+		pending[i].From = tmp
+	}
+	return ordered, nil
+}
+
+func tempName(name string) (string, error) {
+	dir, base := filepath.Split(name)
+	for i := 0; i < 1000; i++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.zmv%d", base, i))
+		if _, err := os.Lstat(tmp); os.IsNotExist(err) {
+			return tmp, nil
+		}
+	}
+	return "", fmt.Errorf("rename: cannot find a temporary name for %s", name)
+}
+
+// Apply performs renames in the order computed by Order, creating missing
+// destination directories. It stops at the first failure.
+func Apply(renames []Rename) error {
+	ordered, err := Order(renames)
+	if err != nil {
+		return err
+	}
+	for _, r := range ordered {
+		if dir := filepath.Dir(r.To); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(r.From, r.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
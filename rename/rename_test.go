@@ -0,0 +1,179 @@
+package rename
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setup(t *testing.T, files ...string) func() {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Join(tmpdir, filepath.Dir(f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	curdir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(curdir)
+		os.RemoveAll(tmpdir)
+	}
+}
+
+func content(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		template string
+		captures []string
+		expected string
+		err      bool
+	}{
+		{`$1$2.jpg`, []string{`a/b/`, `c`}, `a/b/c.jpg`, false},
+		{`${2}_${1}x`, []string{`a`, `b`}, `b_ax`, false},
+		{`$$1`, []string{`a`}, `$1`, false},
+		{`cost$`, nil, `cost$`, false},
+		{`$3`, []string{`a`}, ``, true},
+		{`${1`, []string{`a`}, ``, true},
+		{`$x`, []string{`a`}, ``, true},
+	}
+	for _, test := range tests {
+		got, err := Expand(test.template, test.captures)
+		if (err != nil) != test.err {
+			t.Errorf("Expand(%q): unexpected error %v", test.template, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("Expand(%q): expected %q but got %q", test.template, test.expected, got)
+		}
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	defer setup(t, "a/x.jpeg", "a/b/y.jpeg", "z.jpeg", "keep.png")()
+
+	renames, err := Plan(`**/*.jpeg`, `$1$2.jpg`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Rename{
+		{From: filepath.FromSlash("a/b/y.jpeg"), To: filepath.FromSlash("a/b/y.jpg")},
+		{From: filepath.FromSlash("a/x.jpeg"), To: filepath.FromSlash("a/x.jpg")},
+		{From: "z.jpeg", To: "z.jpg"},
+	}
+	if !reflect.DeepEqual(expected, renames) {
+		t.Fatalf("expected %v but got %v", expected, renames)
+	}
+	if err := Apply(renames); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range expected {
+		if got := content(t, r.To); got != filepath.ToSlash(r.From) {
+			t.Errorf("%s: expected content %q but got %q", r.To, r.From, got)
+		}
+		if _, err := os.Lstat(r.From); !os.IsNotExist(err) {
+			t.Errorf("%s should have been moved", r.From)
+		}
+	}
+}
+
+func TestPlanCollision(t *testing.T) {
+	defer setup(t, "a/x.txt", "b/x.txt")()
+
+	_, err := Plan(`*/*.txt`, `out/$2.txt`, false)
+	if !errors.Is(err, ErrCollision) {
+		t.Errorf("expected %v but got %v", ErrCollision, err)
+	}
+}
+
+func TestPlanExists(t *testing.T) {
+	defer setup(t, "x.txt", "x.bak")()
+
+	_, err := Plan(`*.txt`, `$1.bak`, false)
+	if !errors.Is(err, ErrExists) {
+		t.Errorf("expected %v but got %v", ErrExists, err)
+	}
+	if _, err := Plan(`*.txt`, `$1.bak`, true); err != nil {
+		t.Errorf("expected overwrite to be allowed but got %v", err)
+	}
+}
+
+func TestApplyChainAndCycle(t *testing.T) {
+	defer setup(t, "1", "2", "3", "a", "b")()
+
+	renames := []Rename{
+		{From: "1", To: "2"},
+		{From: "2", To: "3"},
+		{From: "3", To: "4"},
+		{From: "a", To: "b"},
+		{From: "b", To: "a"},
+	}
+	if err := Check(renames, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(renames); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range renames {
+		if got := content(t, r.To); got != r.From {
+			t.Errorf("%s: expected content %q but got %q", r.To, r.From, got)
+		}
+	}
+	if _, err := os.Lstat("1"); !os.IsNotExist(err) {
+		t.Errorf("1 should have been moved")
+	}
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("expected 5 files but got %d", len(files))
+	}
+}
+
+func TestApplyNested(t *testing.T) {
+	defer setup(t, "a/x")()
+
+	renames, err := Plan(`**/*`, `$1$2.bak`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Rename{
+		{From: "a", To: "a.bak"},
+		{From: filepath.FromSlash("a/x"), To: filepath.FromSlash("a/x.bak")},
+	}
+	if !reflect.DeepEqual(expected, renames) {
+		t.Fatalf("expected %v but got %v", expected, renames)
+	}
+	if err := Apply(renames); err != nil {
+		t.Fatal(err)
+	}
+	// a/x is renamed first, then moves along with a.
+	if got := content(t, filepath.FromSlash("a.bak/x.bak")); got != "a/x" {
+		t.Errorf("expected content %q but got %q", "a/x", got)
+	}
+	if _, err := os.Lstat("a"); !os.IsNotExist(err) {
+		t.Errorf("a should have been moved")
+	}
+}
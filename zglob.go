@@ -2,28 +2,432 @@ package zglob
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/mattn/go-zglob/fastwalk"
+	"golang.org/x/text/unicode/norm"
 )
 
+// errStop stops the walk once enough matches have been collected.
+var errStop = errors.New("zglob: enough matches")
+
 var (
-	envre = regexp.MustCompile(`^(\$[a-zA-Z][a-zA-Z0-9_]+|\$\([a-zA-Z][a-zA-Z0-9_]+\))$`)
-	mu    sync.Mutex
+	envre   = regexp.MustCompile(`^(\$[a-zA-Z][a-zA-Z0-9_]+|\$\([a-zA-Z][a-zA-Z0-9_]+\))$`)
+	bracere = regexp.MustCompile(`\$\{[a-zA-Z_][a-zA-Z0-9_]*\}`)
+	mu      sync.Mutex
 )
 
-type zenv struct {
-	dirmask string
+// Pattern is a compiled zglob pattern.
+type Pattern struct {
 	fre     *regexp.Regexp
+	alt     *regexp.Regexp
+	segs    []*regexp.Regexp // per segment, for CouldMatchUnder
 	pattern string
 	root    string
+	dirOnly bool
+	parent  bool // some root Glob walks is outside the current directory
+	opts    options
+}
+
+// Normalization is a Unicode normalization form applied to pattern literals
+// and file names before they are compared.
+type Normalization int
+
+const (
+	// NoNormalization compares pattern and names byte by byte.
+	NoNormalization Normalization = iota
+	// NFC compares pattern and names in Unicode Normalization Form C.
+	NFC
+	// NFD compares pattern and names in Unicode Normalization Form D.
+	NFD
+	// NormalizationInsensitive matches a name when it matches the pattern
+	// in either NFC or NFD, so a character class can match a composed
+	// character as well as its decomposed form.
+	NormalizationInsensitive
+)
+
+// Option configures New, Glob and Match.
+type Option func(*options)
+
+// ErrorPolicy decides what Glob does when a directory cannot be read.
+type ErrorPolicy int
+
+const (
+	// AbortOnError stops at the first error and returns no matches.
+	AbortOnError ErrorPolicy = iota
+	// SkipErrors silently skips directories that cannot be read.
+	SkipErrors
+	// CollectErrors skips directories that cannot be read and returns the
+	// errors as a MultiError alongside the matches.
+	CollectErrors
+)
+
+// MultiError is the list of errors, each an *fs.PathError, collected by
+// Glob under the CollectErrors policy.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors occurred:", len(e))
+	for _, err := range e {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the collected errors for errors.Is and errors.As.
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
+// Is reports whether any of the collected errors matches target, for
+// errors.Is before Go 1.20.
+func (e MultiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the collected errors that matches target, for
+// errors.As before Go 1.20.
+func (e MultiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Type restricts the kind of entries returned by Glob.
+type Type int
+
+const (
+	// AnyType returns files and directories.
+	AnyType Type = iota
+	// Files returns everything but directories.
+	Files
+	// Dirs returns only directories.
+	Dirs
+)
+
+// PathMode is the form of the paths returned by Glob.
+type PathMode int
+
+const (
+	// AsGiven returns paths in the form of the pattern after expansion:
+	// relative to the current directory, or the base directory, for a
+	// relative pattern, and absolute for an absolute one or one starting
+	// with ~.
+	AsGiven PathMode = iota
+	// Absolute returns absolute paths.
+	Absolute
+	// RelativeToRoot returns paths relative to the literal root of the
+	// pattern, so foo/**/*.go returns bar/baz.go for foo/bar/baz.go.
+	RelativeToRoot
+	// RelativeToCWD returns paths relative to the current directory, even
+	// for absolute patterns.
+	RelativeToCWD
+)
+
+type options struct {
+	normalization Normalization
+	fileType      Type
+	minDepth      int
+	maxDepth      int
+	maxResults    int
+	errorPolicy   ErrorPolicy
+	workers       int
+	maxQueued     int
+	sameDevice    bool
+	order         fastwalk.Order
+	noExpand      bool
+	lookupEnv     func(string) (string, bool)
+	base          string
+	pathMode      PathMode
+	native        bool
+	byteExact     bool
+	trace         io.Writer
+}
+
+// WithNormalization makes pattern literals and walked names be normalized
+// to the given form before matching. Matched paths are returned as they are
+// found on disk.
+func WithNormalization(n Normalization) Option {
+	return func(o *options) {
+		o.normalization = n
+	}
+}
+
+// OnlyType makes Glob return only entries of the given type. A pattern
+// ending in a slash, such as */, implies Dirs.
+func OnlyType(t Type) Option {
+	return func(o *options) {
+		o.fileType = t
+	}
+}
+
+// MaxDepth stops Glob from descending more than n levels below the literal
+// root of the pattern, so that foo/**/*.yaml with MaxDepth(1) only returns
+// direct children of foo. Each alternative of a leading brace, as in
+// {src,test}/**, has its own root. Zero means no limit.
+func MaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// MinDepth makes Glob ignore matches less than n levels below the literal
+// root of the pattern.
+func MinDepth(n int) Option {
+	return func(o *options) {
+		o.minDepth = n
+	}
+}
+
+// MaxResults makes Glob stop walking as soon as n matches have been found.
+// Which matches are returned is unspecified. Zero means no limit.
+func MaxResults(n int) Option {
+	return func(o *options) {
+		o.maxResults = n
+	}
+}
+
+// OnError sets how Glob handles directories that cannot be read. The
+// default is AbortOnError.
+func OnError(p ErrorPolicy) Option {
+	return func(o *options) {
+		o.errorPolicy = p
+	}
+}
+
+// Workers sets the number of goroutines Glob uses to read directories.
+// More workers help on network filesystems. Zero means the larger of 4
+// and runtime.NumCPU().
+func Workers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// MaxQueued bounds the number of directories Glob keeps queued for
+// reading, which caps memory use on very wide trees. Zero means no limit.
+func MaxQueued(n int) Option {
+	return func(o *options) {
+		o.maxQueued = n
+	}
+}
+
+// SameFilesystem keeps Glob on the filesystem of the pattern's literal
+// root, like find -xdev, so that /**/*.conf does not wander into /proc or
+// other mounts. Mount points themselves can still match.
+func SameFilesystem() Option {
+	return func(o *options) {
+		o.sameDevice = true
+	}
+}
+
+// BreadthFirst makes Glob visit shallow directories before deep ones, so
+// with MaxResults the matches nearest the root are the ones returned.
+func BreadthFirst() Option {
+	return func(o *options) {
+		o.order = fastwalk.BreadthFirst
+	}
+}
+
+// Sorted makes Glob walk in lexical order, like filepath.Walk, and return
+// its matches in that order.
+func Sorted() Option {
+	return func(o *options) {
+		o.order = fastwalk.Sorted
+	}
+}
+
+// WithBase makes Glob resolve relative patterns against dir instead of the
+// current directory, and return their matches relative to dir.
+func WithBase(dir string) Option {
+	return func(o *options) {
+		o.base = dir
+	}
+}
+
+// OutputPaths sets the form of the paths returned by Glob. The default is
+// AsGiven.
+func OutputPaths(m PathMode) Option {
+	return func(o *options) {
+		o.pathMode = m
+	}
+}
+
+// NativeSeparators makes Glob return paths with the separator of the
+// operating system instead of forward slashes.
+func NativeSeparators() Option {
+	return func(o *options) {
+		o.native = true
+	}
+}
+
+// ByteExact matches patterns and names byte by byte instead of as UTF-8
+// text, so that names that are not valid UTF-8 can be told apart and
+// matched, for example with a class such as [\xff]. Wildcards then count
+// bytes: ? matches one byte of a multi-byte character. Normalization does
+// not apply in this mode, and neither does the case folding done on
+// darwin and windows.
+func ByteExact() Option {
+	return func(o *options) {
+		o.byteExact = true
+	}
+}
+
+// foldCase reports whether names are matched case-insensitively, as they
+// are on darwin and windows unless matching is byte-exact.
+func (o *options) foldCase() bool {
+	return (runtime.GOOS == "windows" || runtime.GOOS == "darwin") && !o.byteExact
+}
+
+// WithLookupEnv sets the function used to expand ~, $VAR, $(VAR) and
+// ${VAR} in patterns. The default is os.LookupEnv.
+func WithLookupEnv(lookup func(key string) (string, bool)) Option {
+	return func(o *options) {
+		o.lookupEnv = lookup
+	}
+}
+
+// WithoutExpansion disables expansion of ~, ~user and environment
+// variables, so patterns from untrusted sources cannot read the process
+// environment or the user database.
+func WithoutExpansion() Option {
+	return func(o *options) {
+		o.noExpand = true
+	}
+}
+
+func (o *options) getenv(key string) string {
+	lookup := o.lookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	v, _ := lookup(key)
+	return v
+}
+
+// expand expands a leading ~ or ~user in the first path segment and
+// environment variables in any segment. Expanded paths use slashes.
+func (o *options) expand(segment string, first bool) string {
+	if o.noExpand {
+		return segment
+	}
+	if first && segment == "~" {
+		if runtime.GOOS == "windows" {
+			return toSlash(o.getenv("USERPROFILE"))
+		}
+		return toSlash(o.getenv("HOME"))
+	}
+	if first && strings.HasPrefix(segment, "~") {
+		if u, err := user.Lookup(segment[1:]); err == nil {
+			return toSlash(u.HomeDir)
+		}
+		return segment
+	}
+	if envre.MatchString(segment) {
+		key := strings.Trim(segment[1:], "()")
+		return toSlash(strings.Trim(o.getenv(key), `"`))
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range bracere.FindAllStringIndex(segment, -1) {
+		if m[0] > 0 && segment[m[0]-1] == '\\' {
+			// An escaped $.
+			continue
+		}
+		b.WriteString(segment[last:m[0]])
+		b.WriteString(toSlash(o.getenv(segment[m[0]+2 : m[1]-1])))
+		last = m[1]
+	}
+	b.WriteString(segment[last:])
+	return b.String()
+}
+
+func (o *options) normalize(s string) string {
+	switch o.normalization {
+	case NFC, NormalizationInsensitive:
+		return norm.NFC.String(s)
+	case NFD:
+		return norm.NFD.String(s)
+	}
+	return s
+}
+
+// latin1 maps each byte of s to the rune of the same value, so that the
+// regexp package sees one character per byte, whether s is valid UTF-8 or
+// not.
+func latin1(s string) string {
+	i := 0
+	for i < len(s) && s[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(2 * len(s))
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// unlatin1 is the inverse of latin1.
+func unlatin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// QuoteMeta returns a pattern that matches the literal text s, escaping
+// every character that New would otherwise treat as a wildcard or expand.
+// Slashes are left alone, so s may be a path. On Windows, where a
+// backslash separates paths, wildcards are quoted with one-character
+// classes instead, which adds a group to Submatch for each of them.
+func QuoteMeta(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '{' || c == '}':
+			b.WriteByte('\\')
+		case runtime.GOOS != "windows" && strings.ContainsRune(`*?[]\!$~,`, c):
+			b.WriteByte('\\')
+		case runtime.GOOS == "windows" && strings.ContainsRune(`*?[!$~`, c):
+			b.WriteByte('[')
+			b.WriteRune(c)
+			b.WriteByte(']')
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
 }
 
 func toSlash(path string) string {
@@ -33,7 +437,7 @@ func toSlash(path string) string {
 	var buf bytes.Buffer
 	cc := []rune(path)
 	for i := 0; i < len(cc); i++ {
-		if i < len(cc)-2 && cc[i] == '\\' && (cc[i+1] == '{' || cc[i+1] == '}') {
+		if i < len(cc)-1 && cc[i] == '\\' && (cc[i+1] == '{' || cc[i+1] == '}') {
 			buf.WriteRune(cc[i])
 			buf.WriteRune(cc[i+1])
 			i++
@@ -46,27 +450,99 @@ func toSlash(path string) string {
 	return buf.String()
 }
 
-func New(pattern string) (*zenv, error) {
+func New(pattern string, opts ...Option) (*Pattern, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.byteExact {
+		o.normalization = NoNormalization
+	}
+	var z *Pattern
+	var err error
+	if o.normalization == NormalizationInsensitive {
+		z, err = compile(pattern, o, norm.NFC.String)
+		if err == nil && z.fre != nil {
+			var alt *Pattern
+			alt, err = compile(pattern, o, norm.NFD.String)
+			if err == nil {
+				z.alt = alt.fre
+			}
+		}
+	} else {
+		z, err = compile(pattern, o, o.normalize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	z.segs = compileSegments(z.pattern, o)
+	roots, _ := z.roots()
+	for _, root := range roots {
+		if root = filepath.ToSlash(root); root == ".." || strings.HasPrefix(root, "../") {
+			z.parent = true
+		}
+	}
+	return z, nil
+}
+
+// anySegment stands for a segment that compileSegments can't judge.
+var anySegment = regexp.MustCompile(``)
+
+// compileSegments compiles each segment of the expanded pattern on its own
+// for CouldMatchUnder. A trailing nil element stands for a segment from
+// which paths of any depth can match, such as **. The result is nil if
+// the pattern can't be split into segments, as when a brace holds a slash.
+func compileSegments(pattern string, o options) []*regexp.Regexp {
+	// The segments are already expanded and normalized.
+	o.noExpand = true
+	o.normalization = NoNormalization
+	parts := strings.Split(path.Clean(pattern), "/")
+	segs := make([]*regexp.Regexp, 0, len(parts))
+	for _, part := range parts {
+		if strings.Count(part, "{") != strings.Count(part, "}") {
+			return nil
+		}
+		switch {
+		case strings.Contains(part, "**"):
+			return append(segs, nil)
+		case strings.ContainsRune(part, '\\'):
+			segs = append(segs, anySegment)
+		case firstMeta(part) < 0:
+			if o.byteExact {
+				part = latin1(part)
+			}
+			pat := regexp.QuoteMeta(part)
+			if o.foldCase() {
+				pat = "(?i:" + pat + ")"
+			}
+			segs = append(segs, regexp.MustCompile("^"+pat+"$"))
+		default:
+			z, err := compile(part, o, o.normalize)
+			if err != nil || z.fre == nil {
+				segs = append(segs, anySegment)
+				continue
+			}
+			segs = append(segs, z.fre)
+		}
+	}
+	return segs
+}
+
+func compile(pattern string, o options, normalize func(string) string) (*Pattern, error) {
 	globmask := ""
 	root := ""
-	for n, i := range strings.Split(toSlash(pattern), "/") {
-		if root == "" && strings.ContainsAny(i, "*{") {
+	dirOnly := len(pattern) > 1 && strings.HasSuffix(toSlash(pattern), "/")
+	segments := strings.Split(toSlash(pattern), "/")
+	for n, i := range segments {
+		i = normalize(o.expand(i, n == 0))
+		segments[n] = i
+		if root == "" && strings.ContainsAny(i, "*?[{\\") {
 			if globmask == "" {
 				root = "."
 			} else {
 				root = toSlash(globmask)
 			}
 		}
-		if n == 0 && i == "~" {
-			if runtime.GOOS == "windows" {
-				i = os.Getenv("USERPROFILE")
-			} else {
-				i = os.Getenv("HOME")
-			}
-		}
-		if envre.MatchString(i) {
-			i = strings.Trim(strings.Trim(os.Getenv(i[1:]), "()"), `"`)
-		}
 
 		globmask = path.Join(globmask, i)
 		if n == 0 {
@@ -77,12 +553,14 @@ func New(pattern string) (*zenv, error) {
 			}
 		}
 	}
+	pattern = strings.Join(segments, "/")
 	if root == "" {
-		return &zenv{
-			dirmask: "",
+		return &Pattern{
 			fre:     nil,
 			pattern: pattern,
 			root:    "",
+			dirOnly: dirOnly,
+			opts:    o,
 		}, nil
 	}
 	if globmask == "" {
@@ -91,29 +569,43 @@ func New(pattern string) (*zenv, error) {
 	globmask = toSlash(path.Clean(globmask))
 
 	cc := []rune(globmask)
+	if o.byteExact {
+		cc = []rune(latin1(globmask))
+	}
 	var dirmask strings.Builder
 	var filemask strings.Builder
 	staticDir := true
 	for i := 0; i < len(cc); i++ {
-		if i < len(cc)-2 && cc[i] == '\\' {
+		if i < len(cc)-1 && cc[i] == '\\' {
 			i++
-			fmt.Fprintf(&filemask, "[\\x%02X]", cc[i])
+			fmt.Fprintf(&filemask, "[\\x{%X}]", cc[i])
 			if staticDir {
 				dirmask.WriteRune(cc[i])
 			}
 		} else if cc[i] == '*' {
 			staticDir = false
 			if i < len(cc)-2 && cc[i+1] == '*' && cc[i+2] == '/' {
-				filemask.WriteString("(.*/)?")
+				filemask.WriteString("((?:.*/)?)")
 				i += 2
 			} else {
-				filemask.WriteString("[^/]*")
+				if i < len(cc)-1 && cc[i+1] == '*' {
+					i++
+				}
+				filemask.WriteString("([^/]*)")
 			}
+		} else if cc[i] == '?' {
+			staticDir = false
+			filemask.WriteString("([^/])")
 		} else if cc[i] == '[' { // range
 			staticDir = false
 			var b strings.Builder
 			for j := i + 1; j < len(cc); j++ {
-				if cc[j] == ']' {
+				if cc[j] == '\\' && j < len(cc)-1 {
+					// Keep the escape, which the regexp understands.
+					b.WriteRune(cc[j])
+					j++
+					b.WriteRune(cc[j])
+				} else if cc[j] == ']' {
 					i = j
 					break
 				} else {
@@ -121,9 +613,9 @@ func New(pattern string) (*zenv, error) {
 				}
 			}
 			if pattern := b.String(); pattern != "" {
-				filemask.WriteByte('[')
+				filemask.WriteString("([")
 				filemask.WriteString(pattern)
-				filemask.WriteByte(']')
+				filemask.WriteString("])")
 				continue
 			}
 		} else {
@@ -131,7 +623,10 @@ func New(pattern string) (*zenv, error) {
 				staticDir = false
 				var b strings.Builder
 				for j := i + 1; j < len(cc); j++ {
-					if cc[j] == ',' {
+					if cc[j] == '\\' && j < len(cc)-1 {
+						j++
+						fmt.Fprintf(&b, "[\\x{%X}]", cc[j])
+					} else if cc[j] == ',' {
 						b.WriteByte('|')
 					} else if cc[j] == '}' {
 						i = j
@@ -171,7 +666,9 @@ func New(pattern string) (*zenv, error) {
 						dirmask.WriteString(m)
 						root = m
 					}
+					filemask.WriteByte('(')
 					filemask.WriteString(pattern)
+					filemask.WriteByte(')')
 					continue
 				}
 			}
@@ -190,10 +687,10 @@ func New(pattern string) (*zenv, error) {
 		if root == "" {
 			root = m
 		}
-		filemask.WriteString("[^/]*")
+		filemask.WriteString("([^/]*)")
 	}
 	var pat string
-	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+	if o.foldCase() {
 		pat = "^(?i:" + filemask.String() + ")$"
 	} else {
 		pat = "^" + filemask.String() + "$"
@@ -202,107 +699,457 @@ func New(pattern string) (*zenv, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &zenv{
-		dirmask: path.Dir(dirmask.String()) + "/",
+	return &Pattern{
 		fre:     fre,
 		pattern: pattern,
 		root:    filepath.Clean(root),
+		dirOnly: dirOnly,
+		opts:    o,
 	}, nil
 }
 
-func Glob(pattern string) ([]string, error) {
-	return glob(pattern, false)
+// resolve returns the on-disk spelling of the literal path p. When
+// normalization is enabled, each element of p that does not exist as
+// written is looked up among its siblings by normalized name.
+func (z *Pattern) resolve(p string) string {
+	if z.opts.normalization == NoNormalization {
+		return p
+	}
+	if _, err := os.Lstat(z.fsPath(p)); err == nil {
+		return p
+	}
+	dir, base := filepath.Split(filepath.Clean(p))
+	if base == "" {
+		return p
+	}
+	parent := "."
+	if dir != "" {
+		parent = z.resolve(filepath.Clean(dir))
+	}
+	want := z.opts.normalize(base)
+	f, err := os.Open(z.fsPath(parent))
+	if err != nil {
+		return p
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return p
+	}
+	for _, name := range names {
+		if z.opts.normalize(name) == want {
+			if dir == "" {
+				return name
+			}
+			return filepath.Join(parent, name)
+		}
+	}
+	return p
 }
 
-func GlobFollowSymlinks(pattern string) ([]string, error) {
-	return glob(pattern, true)
+// matchType reports whether an entry of type typ may be returned.
+func (z *Pattern) matchType(typ os.FileMode) bool {
+	if z.dirOnly || z.opts.fileType == Dirs {
+		return typ.IsDir() && z.opts.fileType != Files
+	}
+	if z.opts.fileType == Files {
+		return !typ.IsDir()
+	}
+	return true
 }
 
-func glob(pattern string, followSymlinks bool) ([]string, error) {
-	zenv, err := New(pattern)
+// descend reports whether the walker may read a directory at depth.
+func (z *Pattern) descend(depth int) bool {
+	return z.opts.maxDepth <= 0 || depth < z.opts.maxDepth
+}
+
+// matchName reports whether the slash-separated name matches the pattern,
+// applying the configured normalization.
+func (z *Pattern) matchName(name string) bool {
+	if z.opts.byteExact {
+		return z.fre.MatchString(latin1(name))
+	}
+	switch z.opts.normalization {
+	case NoNormalization:
+		return z.fre.MatchString(name)
+	case NormalizationInsensitive:
+		return z.fre.MatchString(norm.NFC.String(name)) || z.alt.MatchString(norm.NFD.String(name))
+	}
+	return z.fre.MatchString(z.opts.normalize(name))
+}
+
+func Glob(pattern string, opts ...Option) ([]string, error) {
+	return glob(pattern, false, opts)
+}
+
+func GlobFollowSymlinks(pattern string, opts ...Option) ([]string, error) {
+	return glob(pattern, true, opts)
+}
+
+func glob(pattern string, followSymlinks bool, opts []Option) ([]string, error) {
+	zenv, err := New(pattern, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := zenv.outputDir()
 	if err != nil {
 		return nil, err
 	}
 	if zenv.root == "" {
-		_, err := os.Stat(pattern)
+		// Like the walker, return the path without a trailing slash and
+		// don't follow it if it is a symbolic link.
+		pattern = zenv.resolve(zenv.pattern)
+		if p := strings.TrimRight(pattern, "/"); p != "" && filepath.VolumeName(p) != p {
+			pattern = p
+		}
+		fi, err := os.Lstat(zenv.fsPath(pattern))
 		if err != nil {
 			return nil, os.ErrNotExist
 		}
+		if !zenv.matchType(fi.Mode()) {
+			return []string{}, nil
+		}
+		pattern, err = zenv.output(pattern, dir)
+		if err != nil {
+			return nil, err
+		}
 		return []string{pattern}, nil
 	}
-	relative := !filepath.IsAbs(pattern)
 	matches := []string{}
-
-	err = fastwalk.FastWalk(zenv.root, func(path string, info os.FileMode) error {
-		if zenv.root == "." && len(zenv.root) < len(path) {
-			path = path[len(zenv.root)+1:]
+	var errs MultiError
+	onError := func(path string, err error) error {
+		var perr *fs.PathError
+		if !errors.As(err, &perr) {
+			err = &fs.PathError{Op: "walk", Path: path, Err: err}
+		}
+		switch zenv.opts.errorPolicy {
+		case SkipErrors:
+			return nil
+		case CollectErrors:
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			return nil
 		}
-		path = filepath.ToSlash(path)
+		return err
+	}
+	add := func(path string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		max := zenv.opts.maxResults
+		if max > 0 && len(matches) >= max {
+			return errStop
+		}
+		path, err := zenv.output(path, dir)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, path)
+		if max > 0 && len(matches) >= max {
+			return errStop
+		}
+		return nil
+	}
 
-		if followSymlinks && info == os.ModeSymlink {
-			followedPath, err := filepath.EvalSymlinks(path)
-			if err == nil {
-				fi, err := os.Lstat(followedPath)
-				if err == nil && fi.IsDir() {
-					return fastwalk.TraverseLink
+	walkRoot := func(root string) error {
+		fsroot := zenv.fsPath(root)
+		return fastwalk.Walk(fsroot, func(e fastwalk.Entry) error {
+			path, info, depth := e.Path, e.Type, e.Depth
+			if info.IsDir() && depth == 0 {
+				return nil
+			}
+			if fsroot != root {
+				// Make path relative to the base again.
+				path = filepath.Join(root, path[len(fsroot):])
+			} else if root == "." && len(root) < len(path) {
+				path = path[len(root)+1:]
+			}
+			path = filepath.ToSlash(path)
+
+			if followSymlinks && info == os.ModeSymlink && zenv.descend(depth) && zenv.CouldMatchUnder(path) {
+				followedPath, err := filepath.EvalSymlinks(zenv.fsPath(path))
+				if err == nil {
+					fi, err := os.Lstat(followedPath)
+					if err == nil && fi.IsDir() {
+						return fastwalk.TraverseLink
+					}
 				}
 			}
-		}
 
-		if info.IsDir() {
-			if path == "." || len(path) <= len(zenv.root) {
-				return nil
+			if info.IsDir() {
+				if zenv.matchName(path) {
+					if zenv.matchType(info) && depth >= zenv.opts.minDepth {
+						if err := add(path); err != nil {
+							return err
+						}
+					}
+					if zenv.skipDir(path, depth) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if zenv.skipDir(path, depth) {
+					return filepath.SkipDir
+				}
 			}
-			if zenv.fre.MatchString(path) {
-				mu.Lock()
-				matches = append(matches, path)
-				mu.Unlock()
-				return nil
+
+			if zenv.matchName(path) && zenv.matchType(info) && depth >= zenv.opts.minDepth {
+				return add(path)
 			}
-			if len(path) < len(zenv.dirmask) && !strings.HasPrefix(zenv.dirmask, path+"/") {
-				return filepath.SkipDir
+			return nil
+		}, fastwalk.Options{
+			OnError:    onError,
+			Workers:    zenv.opts.workers,
+			MaxQueued:  zenv.opts.maxQueued,
+			SameDevice: zenv.opts.sameDevice,
+			Order:      zenv.opts.order,
+		})
+	}
+
+	roots, planned := zenv.roots()
+	if paths, ok := zenv.literals(); ok || zenv.readsLevels() {
+		root := zenv.resolve(zenv.root)
+		if _, err = os.Stat(zenv.fsPath(root)); err != nil {
+			err = onError(root, err)
+		} else if ok {
+			for _, p := range paths {
+				if !followSymlinks && zenv.linkBelowRoot(path.Dir(p)) {
+					zenv.trace("skip %s: through a symbolic link", p)
+					continue
+				}
+				zenv.trace("lstat %s", p)
+				if err = zenv.lstat(p, add, onError); err != nil {
+					break
+				}
 			}
+		} else {
+			err = zenv.globLevels(followSymlinks, add, onError)
 		}
-
-		if zenv.fre.MatchString(path) {
-			if relative && filepath.IsAbs(path) {
-				path = path[len(zenv.root)+1:]
+		roots = nil
+	}
+	for _, root := range roots {
+		root = zenv.resolve(root)
+		if planned {
+			// Alternatives that don't exist simply have no matches.
+			if _, err := os.Stat(zenv.fsPath(root)); os.IsNotExist(err) {
+				zenv.trace("skip %s: does not exist", root)
+				continue
+			}
+			if !followSymlinks && zenv.linkBelowRoot(filepath.ToSlash(root)) {
+				zenv.trace("skip %s: through a symbolic link", root)
+				continue
 			}
-			mu.Lock()
-			matches = append(matches, path)
-			mu.Unlock()
 		}
-		return nil
-	})
+		zenv.trace("walk %s", root)
+		if err = walkRoot(root); err != nil {
+			break
+		}
+	}
 
-	if err != nil {
+	if err != nil && err != errStop {
 		return nil, err
 	}
+	if len(errs) > 0 {
+		return matches, errs
+	}
 
 	return matches, nil
 }
 
-func Match(pattern, name string) (matched bool, err error) {
-	zenv, err := New(pattern)
+// Exists reports whether at least one path matches pattern. It stops
+// walking at the first match.
+func Exists(pattern string, opts ...Option) (bool, error) {
+	// Cap opts so that append copies it instead of writing into spare
+	// capacity the caller may share with other goroutines.
+	matches, err := Glob(pattern, append(opts[:len(opts):len(opts)], MaxResults(1))...)
+	if len(matches) > 0 {
+		return true, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return false, nil
+}
+
+// Match reports whether name matches the shell pattern. It is New followed
+// by Pattern.Match.
+func Match(pattern, name string, opts ...Option) (matched bool, err error) {
+	zenv, err := New(pattern, opts...)
 	if err != nil {
 		return false, err
 	}
 	return zenv.Match(name), nil
 }
 
-func (z *zenv) Match(name string) bool {
+// Match reports whether Glob would return the path name for the pattern,
+// given that name exists. Both are compared as cleaned, slash-separated
+// paths, so ./a/b and a/b are the same name. Match does not touch the file
+// system, so a pattern with a trailing slash matches name as if it were a
+// directory, and options that filter by type or depth are not applied.
+func (z *Pattern) Match(name string) bool {
+	name = path.Clean(filepath.ToSlash(name))
 	if z.root == "" {
-		return z.pattern == name
+		return z.opts.normalize(path.Clean(z.pattern)) == z.opts.normalize(name)
 	}
 
-	name = filepath.ToSlash(name)
-
-	if name == "." || len(name) <= len(z.root) {
+	// Glob only returns paths strictly below the root of the pattern,
+	// in the same form, absolute or relative, as the pattern.
+	if name == "." || filepath.IsAbs(name) != filepath.IsAbs(z.pattern) {
 		return false
 	}
+	if !z.parent && (name == ".." || strings.HasPrefix(name, "../")) {
+		return false
+	}
+	return z.matchName(name)
+}
 
-	if z.fre.MatchString(name) {
+// MatchRel is like Match, but a relative name is taken to be relative to
+// the directory base, and an absolute one is made relative to base before
+// it is matched against a relative pattern.
+func (z *Pattern) MatchRel(base, name string) bool {
+	if filepath.IsAbs(z.pattern) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(base, name)
+		}
+		return z.Match(name)
+	}
+	if filepath.IsAbs(name) {
+		abs, err := filepath.Abs(base)
+		if err != nil {
+			return false
+		}
+		rel, err := filepath.Rel(abs, name)
+		if err != nil {
+			return false
+		}
+		name = rel
+	}
+	return z.Match(name)
+}
+
+// outputDir returns the directory that the paths returned by Glob are
+// made relative to for OutputPaths(RelativeToRoot), or the working
+// directory for Absolute and RelativeToCWD, so that it is looked up once
+// per call rather than once per match.
+func (z *Pattern) outputDir() (string, error) {
+	switch z.opts.pathMode {
+	case RelativeToRoot:
+		root := z.root
+		if root == "" {
+			root = literalDir(path.Clean(z.pattern))
+		}
+		return z.fsPath(z.resolve(root)), nil
+	case Absolute, RelativeToCWD:
+		return os.Getwd()
+	}
+	return "", nil
+}
+
+// output converts the slash-separated path p, as found by Glob, to the
+// form selected by the options. dir is the result of outputDir.
+func (z *Pattern) output(p, dir string) (string, error) {
+	var err error
+	switch q := z.fsPath(p); z.opts.pathMode {
+	case Absolute:
+		if !filepath.IsAbs(q) {
+			q = filepath.Join(dir, q)
+		}
+		p = filepath.Clean(q)
+	case RelativeToRoot:
+		p, err = filepath.Rel(dir, q)
+	case RelativeToCWD:
+		if filepath.IsAbs(q) {
+			p, err = filepath.Rel(dir, q)
+		} else {
+			p = filepath.Clean(q)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if z.opts.native {
+		return filepath.FromSlash(p), nil
+	}
+	return filepath.ToSlash(p), nil
+}
+
+// fsPath returns the file system path of p, which is relative to the base
+// directory, if any, when p is relative.
+func (z *Pattern) fsPath(p string) string {
+	if z.opts.base == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(z.opts.base, p)
+}
+
+// CouldMatchUnder reports whether any path below the directory dir could
+// match the pattern, so that a walker may skip dir when it returns false.
+// dir is compared with the pattern segment by segment; when in doubt,
+// CouldMatchUnder returns true.
+func (z *Pattern) CouldMatchUnder(dir string) bool {
+	if z.segs == nil {
 		return true
 	}
-	return false
+	dir = path.Clean(filepath.ToSlash(dir))
+	if dir == "." {
+		return true
+	}
+	switch {
+	case z.opts.byteExact:
+		dir = latin1(dir)
+	case z.opts.normalization == NormalizationInsensitive:
+		dir = norm.NFC.String(dir)
+	default:
+		dir = z.opts.normalize(dir)
+	}
+	for i, part := range strings.Split(strings.TrimSuffix(dir, "/"), "/") {
+		if i < len(z.segs) && z.segs[i] == nil {
+			return true
+		}
+		if i >= len(z.segs)-1 {
+			// dir is as deep as the last segment, which names the
+			// matches themselves.
+			return false
+		}
+		if !z.segs[i].MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// Submatch returns the text matched by each wildcard of the pattern, in
+// the order they appear: one element for every *, **/, ?, character
+// class and brace alternation. A trailing slash only restricts the pattern
+// to directories and yields no element. An unmatched **/ yields an empty
+// string. If name does not match, Submatch returns nil.
+func (z *Pattern) Submatch(name string) []string {
+	if !z.Match(name) {
+		return nil
+	}
+	if z.fre == nil {
+		return []string{}
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	var m []string
+	switch {
+	case z.opts.byteExact:
+		m = z.fre.FindStringSubmatch(latin1(name))
+		for i := range m {
+			m[i] = unlatin1(m[i])
+		}
+	case z.opts.normalization == NoNormalization:
+		m = z.fre.FindStringSubmatch(name)
+	case z.opts.normalization == NormalizationInsensitive:
+		m = z.fre.FindStringSubmatch(norm.NFC.String(name))
+		if m == nil {
+			m = z.alt.FindStringSubmatch(norm.NFD.String(name))
+		}
+	default:
+		m = z.fre.FindStringSubmatch(z.opts.normalize(name))
+	}
+	if m == nil {
+		return nil
+	}
+	return m[1:]
 }
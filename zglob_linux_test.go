@@ -0,0 +1,92 @@
+// +build linux,!fastwalk_portable
+
+package zglob
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGlobSameFilesystem(t *testing.T) {
+	if _, err := os.Stat("/proc/cpuinfo"); err != nil {
+		t.Skip(err)
+	}
+
+	got, err := Glob(`/**/cpuinfo`, MaxDepth(2), OnError(SkipErrors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check([]string{`/proc/cpuinfo`}, got) {
+		t.Skipf("/proc/cpuinfo not found on its own: %v", got)
+	}
+
+	got, err = Glob(`/**/cpuinfo`, MaxDepth(2), OnError(SkipErrors), SameFilesystem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range got {
+		if strings.HasPrefix(m, "/proc/") {
+			t.Errorf("expected SameFilesystem to skip /proc but got %v", got)
+		}
+	}
+}
+
+func TestGlobByteExact(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	names := []string{"bytes/\xff.txt", "bytes/\xfe.txt", "bytes/a\xffb", "bytes/\xc3\xa9.txt", "bytes/\xff/x.txt"}
+	fatalIf(os.MkdirAll("bytes/\xff", 0755))
+	for _, name := range names[:4] {
+		if err := ioutil.WriteFile(name, []byte{}, 0644); err != nil {
+			t.Skip(err)
+		}
+	}
+	fatalIf(ioutil.WriteFile(names[4], []byte{}, 0644))
+
+	tests := []testZGlob{
+		{`bytes/*.txt`, []string{"bytes/\xff.txt", "bytes/\xfe.txt", "bytes/\xc3\xa9.txt"}, ""},
+		{"bytes/\xff*", []string{"bytes/\xff.txt", "bytes/\xff"}, ""},
+		{`bytes/?.txt`, []string{"bytes/\xff.txt", "bytes/\xfe.txt"}, ""},
+		{`bytes/??.txt`, []string{"bytes/\xc3\xa9.txt"}, ""},
+		{`bytes/a[\xff]*`, []string{"bytes/a\xffb"}, ""},
+		{`bytes/[\xfe\xff].*`, []string{"bytes/\xff.txt", "bytes/\xfe.txt"}, ""},
+		{"bytes/\xff/*", []string{"bytes/\xff/x.txt"}, ""},
+		{"**/\xff/*.txt", []string{"bytes/\xff/x.txt"}, ""},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, ByteExact())
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf("pattern %q: expected %q but got %q", test.pattern, test.expected, got)
+		}
+		for _, name := range names {
+			matched, err := Match(test.pattern, name, ByteExact())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := false
+			for _, e := range test.expected {
+				want = want || e == name
+			}
+			if matched != want {
+				t.Errorf("Match(%q, %q): expected %v but got %v", test.pattern, name, want, matched)
+			}
+		}
+	}
+
+	z, err := New(`bytes/*.txt`, ByteExact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := z.Submatch("bytes/\xff.txt"); !reflect.DeepEqual([]string{"\xff"}, got) {
+		t.Errorf("expected the raw byte as submatch but got %q", got)
+	}
+}
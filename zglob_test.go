@@ -1,12 +1,16 @@
 package zglob
 
 import (
+	"bytes"
 	"errors"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strings"
 	"testing"
@@ -32,6 +36,8 @@ var testGlobs = []testZGlob{
 	{`foo/b[a][r]*`, []string{`foo/bar`}, ""},
 	{`foo/b[a-z]*`, []string{`foo/bar`, `foo/baz`}, ""},
 	{`foo/b[c-z]*`, []string{}, ""},
+	{`foo/ba?`, []string{`foo/bar`, `foo/baz`}, ""},
+	{`?oo/b?r`, []string{`foo/bar`, `hoo/bar`}, ""},
 	{`foo/b[z-c]*`, []string{}, "error parsing regexp"},
 	{`foo/**`, []string{`foo/bar`, `foo/baz`}, ""},
 	{`f*o/**`, []string{`foo/bar`, `foo/baz`}, ""},
@@ -146,6 +152,30 @@ func TestMatch(t *testing.T) {
 	}
 }
 
+func TestMatchByteExactCase(t *testing.T) {
+	// Case is never folded byte by byte, even on darwin and windows,
+	// where 0xC3 would otherwise match 0xE3.
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"\xc3*", "\xc3x", true},
+		{"\xc3*", "\xe3x", false},
+		{`a*`, `ax`, true},
+		{`A*`, `ax`, false},
+	}
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.name, ByteExact())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Match(%q, %q): expected %v but got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+}
+
 func TestFollowSymlinks(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("", "zglob")
 	if err != nil {
@@ -182,6 +212,72 @@ func TestFollowSymlinks(t *testing.T) {
 	}
 }
 
+func TestSymlinkPlans(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	os.MkdirAll(filepath.Join(tmpdir, "real/sub"), 0755)
+	os.MkdirAll(filepath.Join(tmpdir, "x"), 0755)
+	ioutil.WriteFile(filepath.Join(tmpdir, "real/sub/y.txt"), []byte{}, 0644)
+	if err := os.Symlink(filepath.Join("..", "real", "sub"), filepath.Join(tmpdir, "x/sub")); err != nil {
+		t.Skip(err.Error())
+	}
+
+	curdir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(curdir)
+
+	// Every plan agrees with the walker, which MaxDepth forces.
+	patterns := []string{`*/sub/*.txt`, `*/sub/y.txt`, `{x,real}/sub/y.txt`, `{x,real}/sub/*.txt`, `**/sub/y.txt`}
+	for _, pattern := range patterns {
+		for _, glob := range []func(string, ...Option) ([]string, error){Glob, GlobFollowSymlinks} {
+			got, err := glob(pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			walked, err := glob(pattern, MaxDepth(100))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !check(walked, got) {
+				t.Errorf("%q: expected %v but got %v", pattern, walked, got)
+			}
+		}
+		got, err := Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{`real/sub/y.txt`}; !check(expected, got) {
+			t.Errorf("%q: expected %v but got %v", pattern, expected, got)
+		}
+	}
+
+	// A trailing slash never matches the link itself, whatever the plan.
+	tests := []testZGlob{
+		{`x/sub/`, []string{}, ""},
+		{`x/su[b]/`, []string{}, ""},
+		{`{x,real}/sub/`, []string{`real/sub`}, ""},
+		{`real/sub/`, []string{`real/sub`}, ""},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check(test.expected, got) {
+			t.Errorf("%q: expected %v but got %v", test.pattern, test.expected, got)
+		}
+	}
+}
+
 func TestGlobError(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("", "zglob")
 	if err != nil {
@@ -233,3 +329,1048 @@ func BenchmarkGlob(b *testing.B) {
 		}
 	}
 }
+
+func TestGlobNormalization(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	nfd := "cafe\u0301"
+	if err := os.MkdirAll(filepath.Join(tmpdir, nfd), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, nfd, "menu.txt"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	curdir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(curdir)
+
+	tests := []struct {
+		pattern  string
+		n        Normalization
+		expected []string
+	}{
+		{"caf\u00e9/*.txt", NFC, []string{nfd + "/menu.txt"}},
+		{"caf\u00e9/*.txt", NFD, []string{nfd + "/menu.txt"}},
+		{"caf\u00e9/*.txt", NormalizationInsensitive, []string{nfd + "/menu.txt"}},
+		{"**/caf\u00e9/*.txt", NFC, []string{nfd + "/menu.txt"}},
+		{"**/caf[\u00e9]/*.txt", NFC, []string{nfd + "/menu.txt"}},
+		{"**/caf[\u00e9]/*.txt", NFD, []string{}},
+		{"**/caf[\u00e9]/*.txt", NormalizationInsensitive, []string{nfd + "/menu.txt"}},
+		{"caf\u00e9/menu.txt", NFC, []string{nfd + "/menu.txt"}},
+		{"{caf\u00e9,tea}/menu.txt", NFC, []string{nfd + "/menu.txt"}},
+		{"{caf\u00e9,tea}/menu.txt", NormalizationInsensitive, []string{nfd + "/menu.txt"}},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, WithNormalization(test.n))
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q(%v): expected %v but got %v`, test.pattern, test.n, test.expected, got)
+		}
+	}
+
+	got, err := Glob("caf\u00e9/*.txt", WithNormalization(NFC), OutputPaths(RelativeToRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"menu.txt"}; !check(expected, got) {
+		t.Errorf("RelativeToRoot: expected %v but got %v", expected, got)
+	}
+
+	// File systems on darwin normalize names themselves.
+	if runtime.GOOS != "darwin" {
+		if _, err := Glob("caf\u00e9/*.txt"); err == nil {
+			t.Errorf("expected error without normalization")
+		}
+	}
+}
+
+func TestMatchNormalization(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		n       Normalization
+		want    bool
+	}{
+		{"caf\u00e9/*.txt", "cafe\u0301/menu.txt", NoNormalization, false},
+		{"caf\u00e9/*.txt", "cafe\u0301/menu.txt", NFC, true},
+		{"cafe\u0301/*.txt", "caf\u00e9/menu.txt", NFD, true},
+		{"caf\u00e9/menu.txt", "cafe\u0301/menu.txt", NFC, true},
+		{"caf[\u00e9]/*.txt", "cafe\u0301/menu.txt", NFD, false},
+		{"caf[\u00e9]/*.txt", "cafe\u0301/menu.txt", NormalizationInsensitive, true},
+	}
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.name, WithNormalization(test.n))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Match(%q, %q) with %v: expected %v but got %v", test.pattern, test.name, test.n, test.want, got)
+		}
+	}
+}
+
+func TestSubmatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected []string
+	}{
+		{`src/**/*.proto`, `src/a/b/c.proto`, []string{`a/b/`, `c`}},
+		{`src/**/*.proto`, `src/c.proto`, []string{``, `c`}},
+		{`foo/b?z/*.txt`, `foo/baz/noo.txt`, []string{`a`, `noo`}},
+		{`foo/b[a-z]*`, `foo/bar`, []string{`a`, `r`}},
+		{`zzz/bar/{baz,z}/*.{jpg,png}`, `zzz/bar/baz/zoo.jpg`, []string{`baz`, `zoo`, `jpg`}},
+		{`foo/**`, `foo/bar`, []string{`bar`}},
+		{`foo/bar`, `foo/bar`, []string{}},
+		{`foo/*/`, `foo/bar`, []string{`bar`}},
+		{`src/**/*.proto`, `src/a/b/c.go`, nil},
+	}
+	for _, test := range tests {
+		z, err := New(test.pattern)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got := z.Submatch(test.name)
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Errorf("Submatch(%q) with %q: expected %q but got %q", test.name, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestExpansion(t *testing.T) {
+	env := map[string]string{
+		"ARCH": "amd64",
+		"TOP":  "src",
+		"HOME": filepath.FromSlash("/home/zglob"),
+		"WORK": filepath.FromSlash("work/zglob"),
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	tests := []struct {
+		pattern string
+		name    string
+		opts    []Option
+		want    bool
+	}{
+		{`build-${ARCH}/*.o`, `build-amd64/main.o`, []Option{WithLookupEnv(lookup)}, true},
+		{`$TOP/**/*.go`, `src/a/b.go`, []Option{WithLookupEnv(lookup)}, true},
+		{`$(TOP)/*.go`, `src/b.go`, []Option{WithLookupEnv(lookup)}, true},
+		{`${TOP}-${ARCH}/*.go`, `src-amd64/b.go`, []Option{WithLookupEnv(lookup)}, true},
+		{`~/*.txt`, `/home/zglob/a.txt`, []Option{WithLookupEnv(lookup)}, true},
+		{`build-${ARCH}/*.o`, `build-amd64/main.o`, []Option{WithLookupEnv(lookup), WithoutExpansion()}, false},
+		{`build-${NOPE}/*.o`, `build-/main.o`, []Option{WithLookupEnv(lookup)}, true},
+		{`$WORK/*.go`, `work/zglob/a.go`, []Option{WithLookupEnv(lookup)}, true},
+		{`${WORK}/**/*.go`, `work/zglob/b/a.go`, []Option{WithLookupEnv(lookup)}, true},
+	}
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.name, test.opts...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Match(%q, %q): expected %v but got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+
+	u, err := user.Current()
+	if err != nil || u.Username == "" || u.HomeDir == "" {
+		t.Skip("no current user")
+	}
+	got, err := Match("~"+u.Username+"/*.txt", filepath.Join(u.HomeDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got {
+		t.Errorf("~%s should expand to %q", u.Username, u.HomeDir)
+	}
+}
+
+func TestGlobExpansion(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	lookup := func(key string) (string, bool) {
+		switch key {
+		case "DIR":
+			return "ba", true
+		case "HOME", "USERPROFILE":
+			return tmpdir, true
+		}
+		return "", false
+	}
+	home := filepath.ToSlash(filepath.Join(tmpdir, "foo/bar/baz.txt"))
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{`foo/${DIR}r/*.txt`, []string{`foo/bar/baz.txt`}},
+		{`~/foo/bar/*.txt`, []string{home}},
+		{`~/foo/bar/baz.txt`, []string{home}},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, WithLookupEnv(lookup))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestGlobType(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	tests := []struct {
+		pattern  string
+		opts     []Option
+		expected []string
+	}{
+		{`foo/*/`, nil, []string{`foo/bar`, `foo/baz`}},
+		{`foo/bar/*/`, nil, []string{`foo/bar/baz`}},
+		{`foo/**/*/`, nil, []string{`foo/bar`, `foo/bar/baz`, `foo/baz`}},
+		{`foo/bar/`, nil, []string{`foo/bar`}},
+		{`foo/**/*`, []Option{OnlyType(Files)}, []string{`foo/bar/baz.txt`, `foo/bar/baz/noo.txt`}},
+		{`foo/**/*`, []Option{OnlyType(Dirs)}, []string{`foo/bar`, `foo/bar/baz`, `foo/baz`}},
+		{`foo/*/`, []Option{OnlyType(Files)}, []string{}},
+		{`foo/bar/baz.txt`, []Option{OnlyType(Dirs)}, []string{}},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, test.opts...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestGlobDepth(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	tests := []struct {
+		pattern  string
+		opts     []Option
+		expected []string
+	}{
+		{`foo/**/*`, []Option{MaxDepth(1)}, []string{`foo/bar`, `foo/baz`}},
+		{`foo/**/*`, []Option{MaxDepth(2)}, []string{`foo/bar`, `foo/bar/baz`, `foo/bar/baz.txt`, `foo/baz`}},
+		{`foo/**/*.txt`, []Option{MaxDepth(2)}, []string{`foo/bar/baz.txt`}},
+		{`foo/**/*`, []Option{MinDepth(2)}, []string{`foo/bar/baz`, `foo/bar/baz.txt`, `foo/bar/baz/noo.txt`}},
+		{`foo/**/*`, []Option{MinDepth(2), MaxDepth(2)}, []string{`foo/bar/baz`, `foo/bar/baz.txt`}},
+		{`**/*.txt`, []Option{MaxDepth(3)}, []string{`foo/bar/baz.txt`}},
+		{`**/*.txt`, []Option{MinDepth(4)}, []string{`foo/bar/baz/noo.txt`}},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, test.opts...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestGlobMaxResults(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	for _, n := range []int{1, 2, 3} {
+		got, err := Glob(`**/*`, MaxResults(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != n {
+			t.Errorf("MaxResults(%d): expected %d matches but got %v", n, n, got)
+		}
+		for _, m := range got {
+			if ok, _ := Match(`**/*`, m); !ok {
+				t.Errorf("MaxResults(%d): unexpected match %q", n, m)
+			}
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{`**/*.png`, true},
+		{`**/*.proto`, false},
+		{`foo/bar/baz.txt`, true},
+		{`foo/bar/nope.txt`, false},
+		{`nope/*.txt`, false},
+		{`nope/**/*.txt`, false},
+		{`nope/{b,c}/c.txt`, false},
+	}
+	// Exists must not write into the spare capacity of the options.
+	opts := make([]Option, 1, 2)
+	opts[0] = OnlyType(AnyType)
+	for _, test := range tests {
+		got, err := Exists(test.pattern, opts...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Exists(%q): expected %v but got %v", test.pattern, test.want, got)
+		}
+	}
+	if opts[:2][1] != nil {
+		t.Errorf("Exists: expected the options to be left alone")
+	}
+}
+
+func TestGlobErrorPolicy(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	got, err := Glob(`nope/**/*.txt`)
+	var perr *fs.PathError
+	if !errors.As(err, &perr) || perr.Path != "nope" {
+		t.Errorf(`expected *fs.PathError for "nope" but got %#v`, err)
+	}
+	if got != nil {
+		t.Errorf(`expected no matches but got %v`, got)
+	}
+
+	got, err = Glob(`nope/**/*.txt`, OnError(SkipErrors))
+	if err != nil {
+		t.Errorf(`expected no error but got %v`, err)
+	}
+	if !check([]string{}, got) {
+		t.Errorf(`expected no matches but got %v`, got)
+	}
+
+	_, err = Glob(`nope/**/*.txt`, OnError(CollectErrors))
+	var merr MultiError
+	if !errors.As(err, &merr) || len(merr) != 1 {
+		t.Fatalf(`expected MultiError with one error but got %v`, err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(`expected %v but got %v`, os.ErrNotExist, err)
+	}
+	// Without the Go 1.20 multi-error unwrapping.
+	if !merr.Is(os.ErrNotExist) {
+		t.Errorf(`expected MultiError.Is(%v) but got %v`, os.ErrNotExist, err)
+	}
+	perr = nil
+	if !merr.As(&perr) || perr.Path != "nope" {
+		t.Errorf(`expected MultiError.As(*fs.PathError) for "nope" but got %v`, err)
+	}
+}
+
+func TestGlobErrorPolicyPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	fatalIf(os.Chmod("foo/bar", 0))
+	defer os.Chmod("foo/bar", 0755)
+
+	got, err := Glob(`**/*.txt`)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf(`expected %v but got %v`, os.ErrPermission, err)
+	}
+
+	got, err = Glob(`**/*`, OnError(CollectErrors))
+	var perr *fs.PathError
+	if !errors.As(err, &perr) || !errors.Is(err, os.ErrPermission) {
+		t.Errorf(`expected *fs.PathError with %v but got %v`, os.ErrPermission, err)
+	}
+	expected := []string{`foo`, `foo/bar`, `foo/baz`, `hoo`, `hoo/bar`, `zzz`, `zzz/bar`, `zzz/bar/baz`, `zzz/bar/baz/joo.png`, `zzz/bar/baz/zoo.jpg`, `zzz/nar`, `zzz/nar/{noo,x}`, `zzz/nar/{noo,x}/joo.png`}
+	if !check(expected, got) {
+		t.Errorf(`zglob failed: expected %v but got %v`, expected, got)
+	}
+}
+
+func TestGlobWorkers(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	for _, test := range testGlobs {
+		if test.err != "" {
+			continue
+		}
+		got, err := Glob(test.pattern, Workers(1), MaxQueued(1))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestGlobOrder(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	for i := 0; i < 10; i++ {
+		got, err := Glob(`**/*.txt`, BreadthFirst(), MaxResults(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]string{`foo/bar/baz.txt`}, got) {
+			t.Errorf("BreadthFirst: expected the shallowest match but got %v", got)
+		}
+	}
+
+	got, err := Glob(`foo/**/*`, Sorted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`foo/bar`, `foo/bar/baz`, `foo/bar/baz/noo.txt`, `foo/bar/baz.txt`, `foo/baz`}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Sorted: expected %v but got %v", expected, got)
+	}
+
+	// Literal paths are returned in the order a Sorted walk finds them.
+	pattern := `{zzz,foo}/bar/{baz.txt,baz/noo.txt,baz}`
+	got, err = Glob(pattern, Sorted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{`foo/bar/baz`, `foo/bar/baz/noo.txt`, `foo/bar/baz.txt`, `zzz/bar/baz`}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Sorted: %q: expected %v but got %v", pattern, expected, got)
+	}
+	walked, err := Glob(pattern, Sorted(), MaxDepth(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walked, got) {
+		t.Errorf("Sorted: %q: expected the walk order %v but got %v", pattern, walked, got)
+	}
+}
+
+func TestPlanRoots(t *testing.T) {
+	tests := []struct {
+		pattern string
+		roots   []string
+		planned bool
+	}{
+		{`{src,test}/**/*.go`, []string{`src`, `test`}, true},
+		{`{src,test/unit}/*.go`, []string{`src`, filepath.FromSlash(`test/unit`)}, true},
+		{`{a,b}/{c,d}/**`, []string{filepath.FromSlash(`a/c`), filepath.FromSlash(`a/d`), filepath.FromSlash(`b/c`), filepath.FromSlash(`b/d`)}, true},
+		{`{a,a/b}/**`, []string{`a`}, true},
+		{`{a,*}/b`, []string{`.`}, true},
+		{`{a.go,b.go}`, []string{`.`}, true},
+		{`src/{a,b}/*.go`, []string{filepath.FromSlash(`src/a`), filepath.FromSlash(`src/b`)}, true},
+		{`{,a}/b`, []string{`.`}, false},
+		{`{a,{b,c}}/**/w.txt`, []string{`.`}, false},
+		{`{a\,b,c}/**`, []string{`.`}, false},
+		{`*/{a,b}`, []string{`.`}, false},
+		{`foo/**/*.go`, []string{`foo`}, false},
+	}
+	for _, test := range tests {
+		z, err := New(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots, planned := z.roots()
+		if !reflect.DeepEqual(test.roots, roots) || planned != test.planned {
+			t.Errorf("%q: expected %v, %v but got %v, %v", test.pattern, test.roots, test.planned, roots, planned)
+		}
+	}
+}
+
+func TestGlobPlannedRoots(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	tests := []testZGlob{
+		{`{foo,zzz}/**/*.png`, []string{`zzz/bar/baz/joo.png`, `zzz/nar/{noo,x}/joo.png`}, ""},
+		{`{foo,nope}/*`, []string{`foo/bar`, `foo/baz`}, ""},
+		{`{zzz/bar,zzz}/**/*.jpg`, []string{`zzz/bar/baz/zoo.jpg`}, ""},
+		{`{foo,hoo}/bar`, []string{`foo/bar`, `hoo/bar`}, ""},
+		{`{nope,none}/*`, []string{}, ""},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestCouldMatchUnder(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		want    bool
+	}{
+		{`foo/*/bar/**/*.go`, `foo`, true},
+		{`foo/*/bar/**/*.go`, `foo/x`, true},
+		{`foo/*/bar/**/*.go`, `foo/x/bar`, true},
+		{`foo/*/bar/**/*.go`, `foo/x/bar/a/b`, true},
+		{`foo/*/bar/**/*.go`, `foo/x/qux`, false},
+		{`foo/*/bar/**/*.go`, `hoo`, false},
+		{`foo/*`, `foo`, true},
+		{`foo/*`, `foo/bar`, false},
+		{`*.go`, `foo`, false},
+		{`./foo/b?r/*`, `foo/bar`, true},
+		{`./foo/b?r/*`, `foo/bor/baz`, false},
+		{`{foo,hoo}/bar/*`, `hoo/bar`, true},
+		{`{foo,hoo}/bar/*`, `zoo`, false},
+		{`{foo,hoo/x}/*`, `zoo`, true},
+		{`/usr/*/bin/*`, `/`, true},
+		{`/usr/*/bin/*`, `/usr/local`, true},
+		{`/usr/*/bin/*`, `/etc`, false},
+		{`**/*.go`, `anything/at/all`, true},
+		{`foo/*/`, `foo/bar`, false},
+		{`zzz/nar/\{noo,x\}/*`, `zzz/nar/{noo,x}`, true},
+	}
+	for _, test := range tests {
+		z, err := New(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := z.CouldMatchUnder(filepath.FromSlash(test.dir)); got != test.want {
+			t.Errorf("New(%q).CouldMatchUnder(%q): expected %v but got %v", test.pattern, test.dir, test.want, got)
+		}
+	}
+}
+
+func TestGlobLevels(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	patterns := []string{
+		`foo/*`, `*oo/bar`, `*/bar/baz.txt`, `zzz/*/baz/*.{jpg,png}`, `?oo/b?r`,
+		`./f*`, `foo/*/`, `zzz/nar/\{noo,x\}/*`, tmpdir + `/foo/*/baz.txt`,
+		`{foo,zzz}/bar/baz.txt`, `zzz/bar/baz/{zoo,joo}.{jpg,png}`, `{foo,nope}/bar`,
+		`foo/bar/baz.txt/*`,
+	}
+	for _, pattern := range patterns {
+		z, err := New(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := z.literals(); !ok && !z.readsLevels() {
+			t.Errorf("%q: expected a literal or level-by-level glob", pattern)
+		}
+		got, err := Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// MaxDepth forces a walk.
+		walked, err := Glob(pattern, MaxDepth(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check(walked, got) {
+			t.Errorf("%q: expected %v but got %v", pattern, walked, got)
+		}
+	}
+
+	got, err := Glob(`zzz/bar/baz/{zoo,joo}.{jpg,png}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`zzz/bar/baz/zoo.jpg`, `zzz/bar/baz/joo.png`}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+}
+
+func TestGlobNestedBraces(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "zglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for _, dir := range []string{`b/x`, `{b}/x`, `a}/x`} {
+		if err := os.MkdirAll(filepath.Join(tmpdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, dir, "w.txt"), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	curdir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(curdir)
+
+	tests := []testZGlob{
+		{`{a,{b,c}}/x/w.txt`, []string{`a}/x/w.txt`, `{b}/x/w.txt`}, ""},
+		{`{a,{b,c}}/**/w.txt`, []string{`a}/x/w.txt`, `{b}/x/w.txt`}, ""},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf(`zglob failed: pattern %q: expected %v but got %v`, test.pattern, test.expected, got)
+		}
+		for _, name := range test.expected {
+			if ok, err := Match(test.pattern, name); !ok || err != nil {
+				t.Errorf("Match(%q, %q): expected true but got %v, %v", test.pattern, name, ok, err)
+			}
+		}
+	}
+}
+
+func TestMatchPaths(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{`*`, `a`, true},
+		{`?`, `a`, true},
+		{`*`, `.`, false},
+		{`*`, `./a`, true},
+		{`./a/b`, `a/b`, true},
+		{`a/b`, `./a/b/`, true},
+		{`a/b/`, `a/b`, true},
+		{`foo/**`, `foo`, false},
+		{`foo/**`, `foo/bar`, true},
+		{`*/b`, `../b`, false},
+		{`**/b`, `/a/b`, false},
+		{`/a/*`, `a/b`, false},
+		{`/a/*`, `/a/b`, true},
+	}
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Match(%q, %q): expected %v but got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+}
+
+func TestMatchConformance(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	var names []string
+	dirs := map[string]bool{}
+	filepath.Walk(".", func(p string, info os.FileInfo, err error) error {
+		if p != "." {
+			names = append(names, filepath.ToSlash(p))
+			dirs[filepath.ToSlash(p)] = info.IsDir()
+		}
+		return nil
+	})
+
+	patterns := []string{
+		`*`, `?oo`, `foo`, `./foo`, `foo/*`, `foo/**`, `foo/**/*`, `**/*`, `**/bar`,
+		`*oo/b*`, `*/bar/*.txt`, `**/*.{jpg,png}`, `{foo,hoo}/bar`, `foo/*/`,
+		`zzz/nar/\{noo,x\}/*`, `foo/bar/baz.txt`,
+		`{foo,../` + filepath.Base(tmpdir) + `/hoo}/*`,
+	}
+	for _, pattern := range patterns {
+		got, err := Glob(pattern)
+		if err != nil {
+			t.Fatalf("Glob(%q): %v", pattern, err)
+		}
+		globbed := map[string]bool{}
+		for _, g := range got {
+			globbed[path.Clean(g)] = true
+			if matched, _ := Match(pattern, g); !matched {
+				t.Errorf("Match(%q, %q) = false, but Glob returned it", pattern, g)
+			}
+		}
+		for _, name := range names {
+			if strings.HasSuffix(pattern, "/") && !dirs[name] {
+				continue
+			}
+			for _, form := range []string{name, "./" + name} {
+				matched, err := Match(pattern, form)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if matched != globbed[name] {
+					t.Errorf("Match(%q, %q) = %v, but Glob returned %v", pattern, form, matched, got)
+				}
+			}
+		}
+		for _, name := range []string{".", "..", "../foo", "/foo", filepath.ToSlash(tmpdir) + "/foo"} {
+			if matched, _ := Match(pattern, name); matched {
+				t.Errorf("Match(%q, %q) = true, but Glob can't return it", pattern, name)
+			}
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	fatalIf(os.Chdir(filepath.Join(tmpdir, "zzz")))
+	for _, base := range []string{tmpdir, ".."} {
+		for _, test := range testGlobs {
+			got, err := Glob(test.pattern, WithBase(base))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("WithBase(%q): pattern %q: expected error %q but got %v", base, test.pattern, test.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if !check(test.expected, got) {
+				t.Errorf("WithBase(%q): pattern %q: expected %v but got %v", base, test.pattern, test.expected, got)
+			}
+		}
+	}
+
+	got, err := Glob(`../foo/*`, WithBase(filepath.Join(tmpdir, "hoo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{`../foo/bar`, `../foo/baz`}; !check(expected, got) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+}
+
+func TestMatchRel(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	if runtime.GOOS == "windows" {
+		root = `C:\repo`
+	}
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{`foo/*.go`, filepath.Join(root, "foo/a.go"), true},
+		{`foo/*.go`, filepath.Join(root, "bar/a.go"), false},
+		{`foo/*.go`, filepath.Join(filepath.Dir(root), "other/foo/a.go"), false},
+		{`foo/*.go`, `foo/a.go`, true},
+		{`foo/*.go`, `./foo/../foo/a.go`, true},
+		{`../other/*`, filepath.Join(filepath.Dir(root), "other/a"), true},
+		{filepath.ToSlash(root) + `/foo/*`, `foo/a`, true},
+		{filepath.ToSlash(root) + `/foo/*`, filepath.Join(root, "foo/a"), true},
+	}
+	for _, test := range tests {
+		z, err := New(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := z.MatchRel(root, test.name); got != test.want {
+			t.Errorf("New(%q).MatchRel(%q, %q): expected %v but got %v", test.pattern, root, test.name, test.want, got)
+		}
+	}
+}
+
+func TestGlobOutputPaths(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	cwd, err := os.Getwd()
+	fatalIf(err)
+	home := func(key string) (string, bool) {
+		if key == "HOME" || key == "USERPROFILE" {
+			return cwd, true
+		}
+		return "", false
+	}
+	abs := func(paths ...string) []string {
+		for i, p := range paths {
+			paths[i] = filepath.ToSlash(filepath.Join(cwd, p))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		pattern  string
+		opts     []Option
+		expected []string
+	}{
+		{`foo/**/*.txt`, nil, []string{`foo/bar/baz.txt`, `foo/bar/baz/noo.txt`}},
+		{`foo/**/*.txt`, []Option{OutputPaths(Absolute)}, abs(`foo/bar/baz.txt`, `foo/bar/baz/noo.txt`)},
+		{`foo/**/*.txt`, []Option{OutputPaths(RelativeToRoot)}, []string{`bar/baz.txt`, `bar/baz/noo.txt`}},
+		{`foo/*/*.txt`, []Option{OutputPaths(RelativeToRoot)}, []string{`bar/baz.txt`}},
+		{`foo/bar/baz.txt`, []Option{OutputPaths(RelativeToRoot)}, []string{`baz.txt`}},
+		{`foo/bar/{baz,x}.txt`, []Option{OutputPaths(RelativeToRoot)}, []string{`baz.txt`}},
+		{`foo/bar/`, []Option{OutputPaths(RelativeToRoot)}, []string{`bar`}},
+		{filepath.ToSlash(cwd) + `/foo/*`, nil, abs(`foo/bar`, `foo/baz`)},
+		{filepath.ToSlash(cwd) + `/foo/*`, []Option{OutputPaths(RelativeToCWD)}, []string{`foo/bar`, `foo/baz`}},
+		{`~/foo/*`, []Option{WithLookupEnv(home)}, abs(`foo/bar`, `foo/baz`)},
+		{`~/foo/*`, []Option{WithLookupEnv(home), OutputPaths(RelativeToCWD)}, []string{`foo/bar`, `foo/baz`}},
+		{`~/foo/*`, []Option{WithLookupEnv(home), OutputPaths(RelativeToRoot)}, []string{`bar`, `baz`}},
+		{`../*/bar/baz.txt`, []Option{WithBase("zzz"), OutputPaths(RelativeToCWD)}, []string{`foo/bar/baz.txt`}},
+		{`**/*.txt`, []Option{WithBase("foo"), OutputPaths(Absolute)}, abs(`foo/bar/baz.txt`, `foo/bar/baz/noo.txt`)},
+		{`foo/**/*.txt`, []Option{NativeSeparators()}, []string{filepath.FromSlash(`foo/bar/baz.txt`), filepath.FromSlash(`foo/bar/baz/noo.txt`)}},
+	}
+	for _, test := range tests {
+		got, err := Glob(test.pattern, test.opts...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !check(test.expected, got) {
+			t.Errorf("pattern %q: expected %v but got %v", test.pattern, test.expected, got)
+		}
+	}
+}
+
+func TestQuoteMeta(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	names := []string{
+		`a*b`, `q?`, `[x]`, `{a,b}`, `a}`, `!(x)`, `a,b`, `$HOME`, `${HOME}`, `$(HOME)`,
+		`~`, `~root`, "日本*", "é[1]",
+	}
+	if runtime.GOOS != "windows" {
+		names = append(names, `we\ird`, `trail\`, `\*`)
+	}
+	fatalIf(os.Mkdir("quote", 0755))
+	for _, name := range names {
+		z, err := New(QuoteMeta(name))
+		if err != nil {
+			t.Errorf("New(QuoteMeta(%q)): %v", name, err)
+			continue
+		}
+		if !z.Match(name) {
+			t.Errorf("QuoteMeta(%q) = %q does not match the name", name, QuoteMeta(name))
+		}
+		for _, other := range []string{"x" + name, name + "x", strings.Replace(name, "*", "x", -1)} {
+			if other != name && z.Match(other) {
+				t.Errorf("QuoteMeta(%q) = %q matches %q", name, QuoteMeta(name), other)
+			}
+		}
+
+		dir := filepath.Join("quote", name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Logf("skipping Glob for %q: %v", name, err)
+			continue
+		}
+		fatalIf(ioutil.WriteFile(filepath.Join(dir, "f.txt"), []byte{}, 0644))
+		for _, pattern := range []string{`quote/` + QuoteMeta(name) + `/*.txt`, `**/` + QuoteMeta(name) + `/f.txt`} {
+			got, err := Glob(pattern)
+			if err != nil {
+				t.Errorf("Glob(%q): %v", pattern, err)
+				continue
+			}
+			if expected := []string{"quote/" + name + "/f.txt"}; !reflect.DeepEqual(expected, got) {
+				t.Errorf("Glob(%q): expected %q but got %q", pattern, expected, got)
+			}
+		}
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("a backslash separates paths on Windows")
+	}
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{`a\*`, `a*`, true},
+		{`a\*`, `ab`, false},
+		{`\*a`, `*a`, true},
+		{`\*a`, `ba`, false},
+		{`*/\?`, `x/?`, true},
+		{`*/\?`, `x/y`, false},
+		{`{x\,y,z}`, `x,y`, true},
+		{`{x\,y,z}`, `z`, true},
+		{`{x\,y,z}`, `x`, false},
+		{`{a\},b}`, `a}`, true},
+		{`[\]a]`, `]`, true},
+		{`[\]a]`, `a`, true},
+		{"\\日*", "日本", true},
+		{`\$HOME/*`, `$HOME/x`, true},
+		{`\${HOME}/*`, `$HOME/x`, true},
+		{`\$\{HOME\}/*`, `${HOME}/x`, true},
+	}
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.name, WithLookupEnv(func(string) (string, bool) { return "home", true }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Match(%q, %q): expected %v but got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		pattern string
+		lines   []string
+	}{
+		{`foo/*/bar/**/*.go`, []string{
+			`tokens   "foo/" "*" "/bar/" "**/" "*" ".go"`,
+			`root     foo`,
+			`plan     walk foo`,
+			`prune    ^foo$ / ^([^/]*)$ / ^bar$ / (any depth)`,
+			`regexp   ^foo/([^/]*)/bar/((?:.*/)?)([^/]*)[\x2E]go$`,
+		}},
+		{`{src,test}/**/*.go`, []string{`plan     walk src, test`}},
+		{`a/{b,c}/d.txt`, []string{`plan     lstat a/b/d.txt, a/c/d.txt`}},
+		{`a/*/d.txt`, []string{`plan     read directories level by level`}},
+		{`a/b`, []string{`plan     stat a/b`}},
+	}
+	for _, test := range tests {
+		z, err := New(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := z.Explain()
+		if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+			continue // case-insensitive regexps
+		}
+		for _, line := range test.lines {
+			if !strings.Contains(got, line+"\n") {
+				t.Errorf("New(%q).Explain(): expected line %q in\n%s", test.pattern, line, got)
+			}
+		}
+	}
+}
+
+func TestGlobTrace(t *testing.T) {
+	tmpdir, savedCwd := setup()
+	defer os.RemoveAll(tmpdir)
+	defer os.Chdir(savedCwd)
+
+	var buf bytes.Buffer
+	if _, err := Glob(`foo/b*r/**/*.txt`, Trace(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"walk foo", "descend foo/bar", "skip foo/baz: nothing below can match"} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in trace\n%s", line, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if _, err := Glob(`zzz/bar/**/*.jpg`, Trace(&buf), MaxDepth(1)); err != nil {
+		t.Fatal(err)
+	}
+	if line := "skip zzz/bar/baz: at MaxDepth 1"; !strings.Contains(buf.String(), line+"\n") {
+		t.Errorf("expected %q in trace\n%s", line, buf.String())
+	}
+}
+
+func TestOverlapsSubsumes(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		overlaps bool
+		subsumes bool // a subsumes b
+	}{
+		{`src/**/*.go`, `**/*_test.go`, true, false},
+		{`src/*.go`, `test/*.go`, false, false},
+		{`*.go`, `*.txt`, false, false},
+		{`a/{b,c}/d`, `a/c/*`, true, false},
+		{`a/?`, `a/bb`, false, false},
+		{`/abs/*`, `abs/*`, false, false},
+		{`foo/[a-c]*`, `foo/[d-f]*`, false, false},
+		{`foo/[a-z]*`, `foo/b*`, true, true},
+		{`**/*.go`, `src/*.go`, true, true},
+		{`src/*.go`, `**/*.go`, true, false},
+		{`src/**/*`, `src/a/b.txt`, true, true},
+		{`src/a/b.txt`, `src/**/*`, true, false},
+		{`*`, `?`, true, true},
+		{`?`, `*`, true, false},
+		{`{a,b}/*`, `a/*`, true, true},
+		{`a/*`, `{a,b}/*`, true, false},
+		{`docs/**`, `docs/*.md`, true, true},
+		{`a/b`, `a/b`, true, true},
+		{`a/b`, `./a/b`, true, true},
+		{`**/x/*`, `*/*/*`, true, false},
+		{`*/*/*`, `a/x/b`, true, true},
+		{`a\*`, `a?`, true, false},
+		{`*`, `..`, false, false},
+		{`?*`, `*`, true, true},
+		{`**/*`, `../*`, false, false},
+		{`../*`, `../a`, true, true},
+		{`foo/*/`, `foo/*`, true, false},
+		{`foo/*`, `foo/*/`, true, true},
+		{`*`, `.`, false, false},
+		{`.`, `.`, true, true},
+		{`{a,../b}/*`, `../b/x`, true, true},
+	}
+	for _, test := range tests {
+		a, err := New(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := New(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Overlaps(a, b); got != test.overlaps {
+			t.Errorf("Overlaps(%q, %q): expected %v but got %v", test.a, test.b, test.overlaps, got)
+		}
+		if got := Overlaps(b, a); got != test.overlaps {
+			t.Errorf("Overlaps(%q, %q): expected %v but got %v", test.b, test.a, test.overlaps, got)
+		}
+		if got := Subsumes(a, b); got != test.subsumes {
+			t.Errorf("Subsumes(%q, %q): expected %v but got %v", test.a, test.b, test.subsumes, got)
+		}
+	}
+
+	files, _ := New(`**/*`, OnlyType(Files))
+	dirs, _ := New(`**/*`, OnlyType(Dirs))
+	all, _ := New(`**/*`)
+	if Subsumes(files, all) || !Subsumes(all, files) || !Subsumes(all, dirs) {
+		t.Errorf("Subsumes: expected OnlyType to restrict what a pattern subsumes")
+	}
+	if Overlaps(files, dirs) || !Overlaps(files, all) {
+		t.Errorf("Overlaps: expected OnlyType(Files) and OnlyType(Dirs) not to overlap")
+	}
+}